@@ -0,0 +1,182 @@
+package es
+
+import (
+	"context"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"sort"
+	"sync"
+)
+
+// result of processing a committed event
+type Report struct {
+	lock    *sync.Mutex
+	EventID primitive.ObjectID
+	// error that prevented the event from being processed at all (e.g. it couldn't be loaded)
+	Error error
+	// result of the projectors the event got handed over to (nil if the projector applied the event)
+	Projectors map[string]error
+	// result of the reactors that reacted on the event
+	Reactors map[string]error
+}
+
+// check if processing the event failed for any of the projectors or reactors
+func (r *Report) Failed() bool {
+
+	if r.Error != nil {
+		return true
+	}
+
+	for _, err := range r.Projectors {
+		if err != nil {
+			return true
+		}
+	}
+
+	for _, err := range r.Reactors {
+		if err != nil {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// record the result of a projector - the projectors are working concurrently
+func (r *Report) projected(projectorName string, err error) {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	r.Projectors[projectorName] = err
+
+}
+
+// record the result of a reactor
+func (r *Report) reacted(reactorName string, err error) {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	r.Reactors[reactorName] = err
+
+}
+
+// the first error of the projectors and reactors (ordered by their name)
+func (r *Report) err() error {
+
+	if r.Error != nil {
+		return r.Error
+	}
+
+	for _, results := range []map[string]error{r.Projectors, r.Reactors} {
+
+		names := []string{}
+		for name := range results {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if results[name] != nil {
+				return results[name]
+			}
+		}
+
+	}
+
+	return nil
+
+}
+
+func newReport(eventID primitive.ObjectID) *Report {
+	return &Report{
+		lock:       &sync.Mutex{},
+		EventID:    eventID,
+		Projectors: map[string]error{},
+		Reactors:   map[string]error{},
+	}
+}
+
+// returned by commit in order to wait for the committed event to be processed
+type CommitHandle struct {
+	eventID primitive.ObjectID
+	// closed once the event got processed
+	processed chan struct{}
+	report    *Report
+	// the event got committed before with the same idempotency key
+	duplicate bool
+}
+
+// id of the committed event
+func (h *CommitHandle) EventID() primitive.ObjectID {
+	return h.eventID
+}
+
+// true in case an event with the same idempotency key got committed before. The handle belongs to the original event.
+func (h *CommitHandle) Duplicate() bool {
+	return h.duplicate
+}
+
+// Wait till the event got processed by all projectors and reactors. The report contains the result of each of them.
+// The context error is returned in case the context is done before.
+func (h *CommitHandle) Wait(ctx context.Context) (*Report, error) {
+
+	select {
+	case <-h.processed:
+		return h.report, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+}
+
+func newCommitHandle(e processEvent) *CommitHandle {
+
+	h := &CommitHandle{
+		eventID:   e.eventID,
+		processed: make(chan struct{}),
+		report:    e.report,
+	}
+
+	// the processor signals only once - closing the channel allows us to wait multiple times
+	go func() {
+		<-e.onProcessed
+		close(h.processed)
+	}()
+
+	return h
+
+}
+
+// handle of an event that got committed before with the same idempotency key
+func newDuplicateCommitHandle(original *CommitHandle) *CommitHandle {
+	return &CommitHandle{
+		eventID:   original.eventID,
+		processed: original.processed,
+		report:    original.report,
+		duplicate: true,
+	}
+}
+
+// Handle of an event that got committed earlier (e.g. by another instance). We don't know the result of processing it,
+// so the report is empty.
+func newCommittedHandle(eventID primitive.ObjectID) *CommitHandle {
+
+	h := &CommitHandle{
+		eventID:   eventID,
+		processed: make(chan struct{}),
+		report:    newReport(eventID),
+		duplicate: true,
+	}
+	close(h.processed)
+
+	return h
+
+}
@@ -0,0 +1,68 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCommitHandle(t *testing.T) {
+
+	Convey("commit handle", t, func() {
+
+		Convey("wait must respect the context", func() {
+
+			handle := newCommitHandle(processEvent{
+				eventID:     primitive.NewObjectID(),
+				onProcessed: make(chan struct{}, 1),
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			report, err := handle.Wait(ctx)
+			So(report, ShouldBeNil)
+			So(err, ShouldResemble, context.Canceled)
+
+		})
+
+		Convey("it must be possible to wait multiple times", func() {
+
+			eventID := primitive.NewObjectID()
+			e := processEvent{
+				eventID:     eventID,
+				onProcessed: make(chan struct{}, 1),
+				report:      newReport(eventID),
+			}
+			handle := newCommitHandle(e)
+			So(handle.EventID(), ShouldEqual, eventID)
+
+			e.onProcessed <- struct{}{}
+
+			report, err := handle.Wait(context.Background())
+			So(err, ShouldBeNil)
+			So(report.EventID, ShouldEqual, eventID)
+			So(report.Failed(), ShouldBeFalse)
+
+			report, err = handle.Wait(context.Background())
+			So(err, ShouldBeNil)
+			So(report.EventID, ShouldEqual, eventID)
+
+		})
+
+		Convey("report failed in case a projector or reactor failed", func() {
+
+			report := newReport(primitive.NewObjectID())
+			report.projected("user.projector", nil)
+			So(report.Failed(), ShouldBeFalse)
+
+			report.reacted("user.reactor", errors.New("failed to send mail"))
+			So(report.Failed(), ShouldBeTrue)
+
+		})
+
+	})
+
+}
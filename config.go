@@ -0,0 +1,179 @@
+package es
+
+import (
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/projector"
+)
+
+// returned by commit in case the event queue is full and the QueueFullFailFast policy is used
+var ErrQueueFull = errors.New("event queue is full")
+
+// defines what happens when an event is committed while the event queue of the processor is full
+type QueueFullPolicy int
+
+const (
+	// wait till there is space in the queue (or the context of the commit is done)
+	QueueFullBlock QueueFullPolicy = iota
+	// Don't persist the event and return ErrQueueFull. In case the queue filled up after the event got persisted the
+	// commit succeeds and the processor catches up on the event from the event store.
+	QueueFullFailFast
+	// persist the event and let the processor catch up on it from the event store
+	QueueFullPersistOnly
+)
+
+// configuration of an event sourcing instance
+type config struct {
+	// size of the event queue of each projector
+	projectorQueueSize int
+	// maximum amount of events handed over to a batch projector at once
+	projectorBatchSize int
+	// size of the event queue of the processor
+	eventQueueSize int
+	// what to do when the event queue of the processor is full
+	queueFullPolicy QueueFullPolicy
+	// apply events to projectors that already handled them
+	forceReprocessing bool
+	// maximum amount of reactors that react on events at the same time
+	reactorWorkers int
+	// the commit handle doesn't wait for the reactors
+	detachedReactors bool
+	// maximum length of a chain of follow up events
+	maxCausationDepth int
+	// keeps the checkpoints of the projectors (defaults to the projectors collection of the database)
+	projectorRepository projector.IProjectorRepository
+}
+
+type Option func(config *config)
+
+// size of the event queue of each projector (defaults to 100). The processor only blocks once the queue of a projector is full.
+func WithProjectorQueueSize(size int) Option {
+	return func(config *config) {
+		config.projectorQueueSize = size
+	}
+}
+
+// the maximum amount of queued events that are handed over to projectors that implement projector.IBatchProjector at once
+// (defaults to 100).
+func WithProjectorBatchSize(events int) Option {
+	return func(config *config) {
+		config.projectorBatchSize = events
+	}
+}
+
+// size of the queue of committed events that wait to be processed (defaults to 100)
+func WithEventQueueSize(size int) Option {
+	return func(config *config) {
+		config.eventQueueSize = size
+	}
+}
+
+// what to do when an event is committed while the event queue is full (defaults to QueueFullBlock)
+func WithQueueFullPolicy(policy QueueFullPolicy) Option {
+	return func(config *config) {
+		config.queueFullPolicy = policy
+	}
+}
+
+// Apply events to projectors even if they already handled them (e.g. after fixing a bug in a projector). By default
+// events that are not after the last handled event of a projector are skipped. The checkpoint is never moved back.
+func WithForcedReprocessing() Option {
+	return func(config *config) {
+		config.forceReprocessing = true
+	}
+}
+
+// The maximum amount of reactors that react on events at the same time (defaults to 10). Reactors run in the background,
+// so a slow reactor doesn't delay the projectors. How many events a single reactor reacts on at the same time is
+// defined when registering it (see reactor.WithConcurrency).
+func WithReactorWorkers(workers int) Option {
+	return func(config *config) {
+		config.reactorWorkers = workers
+	}
+}
+
+// Don't wait for the reactors when waiting for a commit. The commit handle is done once the projectors applied the
+// event and the report doesn't contain the results of the reactors.
+func WithDetachedReactors() Option {
+	return func(config *config) {
+		config.detachedReactors = true
+	}
+}
+
+// The maximum amount of follow up events in a causation chain (defaults to 10). A reactor that emits a follow up event
+// beyond it fails with ErrCausationLoop - the reactors most likely trigger each other in a cycle.
+func WithMaxCausationDepth(depth int) Option {
+	return func(config *config) {
+		config.maxCausationDepth = depth
+	}
+}
+
+// Keep the checkpoints of the projectors in the given repository instead of the projectors collection of the database,
+// e.g. the repository created by projector.NewSQLProjectorRepository to write the read models of your projectors and
+// their checkpoints into a sql database. Replays into the live projectors use the repository as well.
+func WithProjectorRepository(repository projector.IProjectorRepository) Option {
+	return func(config *config) {
+		config.projectorRepository = repository
+	}
+}
+
+func newConfig(options ...Option) *config {
+
+	config := &config{
+		projectorQueueSize: 100,
+		projectorBatchSize: 100,
+		eventQueueSize:     100,
+		queueFullPolicy:    QueueFullBlock,
+		reactorWorkers:     10,
+		maxCausationDepth:  10,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	if config.projectorQueueSize < 1 {
+		config.projectorQueueSize = 1
+	}
+
+	if config.projectorBatchSize < 1 {
+		config.projectorBatchSize = 1
+	}
+
+	if config.eventQueueSize < 1 {
+		config.eventQueueSize = 1
+	}
+
+	if config.reactorWorkers < 1 {
+		config.reactorWorkers = 1
+	}
+
+	return config
+
+}
+
+// configuration of a single commit
+type commitConfig struct {
+	idempotencyKey string
+}
+
+type CommitOption func(config *commitConfig)
+
+// Make the commit idempotent. The key is stored with the event under a unique index. Committing again with the same key
+// doesn't append another event - the handle of the original event is returned instead.
+func WithIdempotencyKey(key string) CommitOption {
+	return func(config *commitConfig) {
+		config.idempotencyKey = key
+	}
+}
+
+func newCommitConfig(options ...CommitOption) *commitConfig {
+
+	config := &commitConfig{}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	return config
+
+}
@@ -0,0 +1,171 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+	"runtime/debug"
+	"time"
+)
+
+// returned in case there is no dead letter with the given id
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// An event that a projector or reactor couldn't handle. There is one dead letter per event and projector (or reactor).
+type DeadLetter struct {
+	ID        *primitive.ObjectID `bson:"_id,omitempty"`
+	EventID   primitive.ObjectID  `bson:"event_id"`
+	EventName string              `bson:"event_name"`
+	// the projector that failed to handle the event
+	Projector string `bson:"projector"`
+	// the reactor that failed to handle the event
+	Reactor string `bson:"reactor"`
+	// the last error - neither the projector nor the reactor is set in case the event couldn't be decoded
+	Error string `bson:"error"`
+	// stack of the go routine that failed to handle the event
+	Stack string `bson:"stack"`
+	// amount of attempts to handle the event (including retries)
+	Attempts int `bson:"attempts"`
+	// unix timestamps
+	CreatedAt int64 `bson:"created_at"`
+	UpdatedAt int64 `bson:"updated_at"`
+}
+
+// create the dead letter for the event the projector (or reactor) failed to handle with the given error
+func newDeadLetter(persistedEvent event.Event, projectorName string, reactorName string, err error, attempts int) DeadLetter {
+
+	letter := DeadLetter{
+		EventID:   *persistedEvent.ID,
+		EventName: persistedEvent.Name,
+		Projector: projectorName,
+		Reactor:   reactorName,
+		Error:     err.Error(),
+		Stack:     string(debug.Stack()),
+		Attempts:  attempts,
+	}
+
+	// the stack of the panic is more helpful than ours
+	if panicErr, k := err.(*PanicError); k {
+		letter.Stack = string(panicErr.Stack)
+	}
+
+	return letter
+
+}
+
+type deadLetterRepository interface {
+	// Record the dead letter. In case there is already one for the event and the projector (or reactor) the error and
+	// the stack are replaced and the attempts are added up.
+	Save(letter DeadLetter) error
+	// all dead letters (oldest first)
+	All() ([]DeadLetter, error)
+	// fetch dead letter - ErrDeadLetterNotFound is returned if it doesn't exist
+	FetchByID(id primitive.ObjectID) (DeadLetter, error)
+	// delete the dead letter
+	Delete(id primitive.ObjectID) error
+}
+
+type mongoDeadLetterRepository struct {
+	deadLetterCollection *mongo.Collection
+}
+
+func (r *mongoDeadLetterRepository) Save(letter DeadLetter) error {
+
+	// create dead letter if it doesn't exist
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	now := time.Now().Unix()
+
+	_, err := r.deadLetterCollection.UpdateOne(
+		context.Background(),
+		bson.M{
+			"event_id":  letter.EventID,
+			"projector": letter.Projector,
+			"reactor":   letter.Reactor,
+		},
+		bson.M{
+			"$set": bson.M{
+				"event_name": letter.EventName,
+				"error":      letter.Error,
+				"stack":      letter.Stack,
+				"updated_at": now,
+			},
+			"$inc": bson.M{
+				"attempts": letter.Attempts,
+			},
+			"$setOnInsert": bson.M{
+				"created_at": now,
+			},
+		},
+		updateOptions,
+	)
+
+	return err
+
+}
+
+func (r *mongoDeadLetterRepository) All() ([]DeadLetter, error) {
+
+	// the ids are ordered by creation
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"_id": 1})
+
+	cursor, err := r.deadLetterCollection.Find(context.Background(), bson.M{}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	letters := []DeadLetter{}
+	for cursor.Next(ctx) {
+		letter := DeadLetter{}
+		if err := cursor.Decode(&letter); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, cursor.Close(ctx)
+
+}
+
+func (r *mongoDeadLetterRepository) FetchByID(id primitive.ObjectID) (DeadLetter, error) {
+
+	// fetch dead letter
+	result := r.deadLetterCollection.FindOne(context.Background(), bson.M{
+		"_id": id,
+	})
+
+	letter := DeadLetter{}
+
+	// decode fetched dead letter
+	err := result.Decode(&letter)
+	switch err {
+	case nil:
+		return letter, nil
+	case mongo.ErrNoDocuments:
+		return letter, ErrDeadLetterNotFound
+	default:
+		return letter, err
+	}
+
+}
+
+func (r *mongoDeadLetterRepository) Delete(id primitive.ObjectID) error {
+	_, err := r.deadLetterCollection.DeleteOne(context.Background(), bson.M{
+		"_id": id,
+	})
+	return err
+}
+
+func newDeadLetterRepository(deadLetterCollection *mongo.Collection) *mongoDeadLetterRepository {
+	return &mongoDeadLetterRepository{
+		deadLetterCollection: deadLetterCollection,
+	}
+}
@@ -0,0 +1,86 @@
+package es
+
+import (
+	"context"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDeadLetterRepository(t *testing.T) {
+
+	var createDB = func() (*mongo.Database, error) {
+
+		// create client
+		client, err := mongo.Connect(context.TODO(), "mongodb://localhost:8034")
+		if err != nil {
+			return nil, err
+		}
+
+		// database
+		db := client.Database("godb")
+		err = db.Drop(context.Background())
+
+		return db, err
+	}
+
+	Convey("dead letter repository", t, func() {
+
+		Convey("record, fetch and delete dead letters", func() {
+
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			deadLetterRepository := newDeadLetterRepository(db.Collection("dead_letters"))
+
+			eventID := primitive.NewObjectID()
+			So(deadLetterRepository.Save(DeadLetter{
+				EventID:   eventID,
+				EventName: "user.registered",
+				Projector: "user.projector",
+				Error:     "first error",
+				Attempts:  3,
+			}), ShouldBeNil)
+
+			// the same event failed for the projector again
+			So(deadLetterRepository.Save(DeadLetter{
+				EventID:   eventID,
+				EventName: "user.registered",
+				Projector: "user.projector",
+				Error:     "second error",
+				Attempts:  1,
+			}), ShouldBeNil)
+
+			// the event failed for another projector
+			So(deadLetterRepository.Save(DeadLetter{
+				EventID:   eventID,
+				EventName: "user.registered",
+				Projector: "account.projector",
+				Error:     "error",
+				Attempts:  1,
+			}), ShouldBeNil)
+
+			letters, err := deadLetterRepository.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 2)
+			So(letters[0].Projector, ShouldEqual, "user.projector")
+			So(letters[0].Error, ShouldEqual, "second error")
+			So(letters[0].Attempts, ShouldEqual, 4)
+			So(letters[1].Projector, ShouldEqual, "account.projector")
+
+			// fetch
+			letter, err := deadLetterRepository.FetchByID(*letters[0].ID)
+			So(err, ShouldBeNil)
+			So(letter, ShouldResemble, letters[0])
+
+			// delete
+			So(deadLetterRepository.Delete(*letters[0].ID), ShouldBeNil)
+			_, err = deadLetterRepository.FetchByID(*letters[0].ID)
+			So(err, ShouldEqual, ErrDeadLetterNotFound)
+
+		})
+
+	})
+
+}
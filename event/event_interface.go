@@ -8,3 +8,10 @@ type IESEvent interface {
 	// commit date
 	OccurredAt() int64
 }
+
+// events that belong to a stream (e.g. an aggregate)
+type IStreamEvent interface {
+	IESEvent
+	// id of the stream the event belongs to
+	StreamID() string
+}
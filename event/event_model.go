@@ -10,4 +10,23 @@ type Event struct {
 	Payload    map[string]interface{} `bson:"payload"`
 	Version    uint8                  `bson:"version"`
 	OccurredAt int64                  `bson:"occurred_at"`
+	// key supplied by the committer to make the commit idempotent (unique)
+	IdempotencyKey string `bson:"idempotency_key,omitempty"`
+	// the event a reactor reacted on when committing this event (nil if the event wasn't committed by a reactor)
+	CausationID *primitive.ObjectID `bson:"causation_id,omitempty"`
+	// the first event of the causation chain (nil if the event wasn't committed by a reactor)
+	CorrelationID *primitive.ObjectID `bson:"correlation_id,omitempty"`
+	// amount of events in the causation chain before this event
+	CausationDepth int `bson:"causation_depth,omitempty"`
+}
+
+// the id of the first event of the causation chain - the own id in case the event wasn't committed by a reactor
+func (e Event) Correlation() *primitive.ObjectID {
+
+	if e.CorrelationID != nil {
+		return e.CorrelationID
+	}
+
+	return e.ID
+
 }
@@ -6,25 +6,114 @@ import (
 	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+	"sync"
 )
 
+// selection of events
+type Query struct {
+	// only select events that were persisted after the given event
+	After *primitive.ObjectID
+	// only select events starting with (and including) the given event
+	From *primitive.ObjectID
+	// only select events up to (and including) the given event
+	Until *primitive.ObjectID
+	// only select events with the given names
+	Names []string
+	// only select events that occurred at or after the given unix timestamp (ignored if 0)
+	OccurredFrom int64
+	// only select events that occurred at or before the given unix timestamp (ignored if 0)
+	OccurredTo int64
+}
+
+func (q Query) filter() bson.M {
+
+	filter := bson.M{}
+
+	// position of the event
+	id := bson.M{}
+	if q.After != nil {
+		id["$gt"] = q.After
+	}
+	if q.From != nil {
+		id["$gte"] = q.From
+	}
+	if q.Until != nil {
+		id["$lte"] = q.Until
+	}
+	if len(id) > 0 {
+		filter["_id"] = id
+	}
+
+	// event names
+	if len(q.Names) > 0 {
+		filter["name"] = bson.M{
+			"$in": q.Names,
+		}
+	}
+
+	// time range
+	occurredAt := bson.M{}
+	if q.OccurredFrom != 0 {
+		occurredAt["$gte"] = q.OccurredFrom
+	}
+	if q.OccurredTo != 0 {
+		occurredAt["$lte"] = q.OccurredTo
+	}
+	if len(occurredAt) > 0 {
+		filter["occurred_at"] = occurredAt
+	}
+
+	return filter
+
+}
+
+// mongo error code of a duplicate key error
+const duplicateKeyErrorCode = 11000
+
+// returned when saving an event with an idempotency key that has already been used
+var ErrDuplicateIdempotencyKey = errors.New("an event with the given idempotency key has already been saved")
+
 type IEventRepository interface {
 	// save event
 	Save(event *Event) error
+	// save event - the context is respected while persisting it
+	SaveContext(ctx context.Context, event *Event) error
 	// fetch event by it's id
 	FetchByID(id primitive.ObjectID) (Event, error)
-	// map over all events
-	Map(cb func(eventID primitive.ObjectID)) error
+	// fetch event by it's idempotency key
+	FetchByIdempotencyKey(ctx context.Context, key string) (Event, error)
+	// map over the events matching the query (ordered by their id)
+	Map(query Query, cb func(event Event)) error
+	// count the events matching the query
+	Count(query Query) (int64, error)
 }
 
 type eventRepository struct {
 	eventCollection *mongo.Collection
+	// guards the creation of the idempotency key index
+	lock           *sync.Mutex
+	indexesCreated bool
 }
 
 func (r *eventRepository) Save(event *Event) error {
+	return r.SaveContext(context.Background(), event)
+}
+
+func (r *eventRepository) SaveContext(ctx context.Context, event *Event) error {
+
+	// the idempotency key must be unique
+	if event.IdempotencyKey != "" {
+		if err := r.createIndexes(ctx); err != nil {
+			return err
+		}
+	}
 
 	// insert the event
-	insertionResult, err := r.eventCollection.InsertOne(context.Background(), event)
+	insertionResult, err := r.eventCollection.InsertOne(ctx, event)
+	if IsDuplicateKeyError(err) {
+		return ErrDuplicateIdempotencyKey
+	}
 	if err != nil {
 		return err
 	}
@@ -39,30 +128,46 @@ func (r *eventRepository) Save(event *Event) error {
 	return err
 }
 
-func (r *eventRepository) Map(cb func(primitive.ObjectID)) error {
+func (r *eventRepository) Map(query Query, cb func(event Event)) error {
+	return r.MapContext(context.Background(), query, cb)
+}
+
+// map over the events matching the query (ordered by their id) till the context is done - the context error is
+// returned in that case
+func (r *eventRepository) MapContext(ctx context.Context, query Query, cb func(event Event)) error {
+
+	// sort by id so that we can continue after a given event
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"_id": 1})
 
 	// create event cursor
-	cursor, err := r.eventCollection.Find(context.Background(), bson.M{})
+	cursor, err := r.eventCollection.Find(ctx, query.filter(), findOptions)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
-
 	// start iterating over the event
 	for cursor.Next(ctx) {
-		event := &Event{}
-		// @todo would be better if we would receive only the id
+		event := Event{}
 		if err := cursor.Decode(&event); err != nil {
 			return err
 		}
-		cb(*event.ID)
+		cb(event)
+	}
+
+	if err := ctx.Err(); err != nil {
+		_ = cursor.Close(context.Background())
+		return err
 	}
 
 	return cursor.Close(ctx)
 
 }
 
+func (r *eventRepository) Count(query Query) (int64, error) {
+	return r.eventCollection.Count(context.Background(), query.filter())
+}
+
 func (r *eventRepository) FetchByID(id primitive.ObjectID) (Event, error) {
 
 	// find event by it's id
@@ -78,8 +183,74 @@ func (r *eventRepository) FetchByID(id primitive.ObjectID) (Event, error) {
 
 }
 
+func (r *eventRepository) FetchByIdempotencyKey(ctx context.Context, key string) (Event, error) {
+
+	// find event by it's idempotency key
+	result := r.eventCollection.FindOne(ctx, bson.M{"idempotency_key": key})
+
+	// decode event
+	e := Event{}
+	if err := result.Decode(&e); err != nil {
+		return Event{}, err
+	}
+
+	return e, nil
+
+}
+
+// create the unique index of the idempotency key. Events without a key are not part of the index.
+func (r *eventRepository) createIndexes(ctx context.Context) error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	if r.indexesCreated {
+		return nil
+	}
+
+	indexOptions := options.Index()
+	indexOptions.SetName("idempotency_key")
+	indexOptions.SetUnique(true)
+	indexOptions.SetSparse(true)
+
+	_, err := r.eventCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"idempotency_key": 1},
+		Options: indexOptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.indexesCreated = true
+
+	return nil
+
+}
+
+// check if the mongo write failed because of a unique index
+func IsDuplicateKeyError(err error) bool {
+
+	writeException, k := err.(mongo.WriteException)
+	if !k {
+		return false
+	}
+
+	for _, writeError := range writeException.WriteErrors {
+		if writeError.Code == duplicateKeyErrorCode {
+			return true
+		}
+	}
+
+	return false
+
+}
+
 func NewEventRepository(eventCollection *mongo.Collection) *eventRepository {
 	return &eventRepository{
 		eventCollection: eventCollection,
+		lock:            &sync.Mutex{},
 	}
 }
@@ -51,6 +51,35 @@ func TestEventRepository(t *testing.T) {
 
 			})
 
+			Convey("idempotency key must be unique", func() {
+
+				db, err := createDB()
+				So(err, ShouldBeNil)
+
+				eventRepository := NewEventRepository(db.Collection("events"))
+
+				// events without a key are not affected by the unique index
+				So(eventRepository.Save(&Event{Name: "user.created"}), ShouldBeNil)
+				So(eventRepository.Save(&Event{Name: "user.created"}), ShouldBeNil)
+
+				original := &Event{
+					Name:           "user.created",
+					IdempotencyKey: "request-1",
+				}
+				So(eventRepository.SaveContext(context.Background(), original), ShouldBeNil)
+
+				err = eventRepository.SaveContext(context.Background(), &Event{
+					Name:           "user.created",
+					IdempotencyKey: "request-1",
+				})
+				So(err, ShouldEqual, ErrDuplicateIdempotencyKey)
+
+				fetchedEvent, err := eventRepository.FetchByIdempotencyKey(context.Background(), "request-1")
+				So(err, ShouldBeNil)
+				So(fetchedEvent.ID, ShouldResemble, original.ID)
+
+			})
+
 		})
 
 		Convey("get by id", func() {
@@ -125,8 +154,8 @@ func TestEventRepository(t *testing.T) {
 			mappedEventsChannel := make(chan primitive.ObjectID, 5)
 
 			// map over persisted events
-			err = eventRepository.Map(func(id primitive.ObjectID) {
-				mappedEventsChannel <- id
+			err = eventRepository.Map(Query{}, func(e Event) {
+				mappedEventsChannel <- *e.ID
 			})
 			So(err, ShouldBeNil)
 
@@ -135,6 +164,94 @@ func TestEventRepository(t *testing.T) {
 			So(<-mappedEventsChannel, ShouldEqual, *secondEvent.ID)
 			So(<-mappedEventsChannel, ShouldEqual, *thirdEvent.ID)
 
+			Convey("map only events after a given event", func() {
+
+				// mapped events channel
+				mappedEventsChannel := make(chan primitive.ObjectID, 5)
+
+				// map over the events after the first event
+				err = eventRepository.Map(Query{After: firstEvent.ID}, func(e Event) {
+					mappedEventsChannel <- *e.ID
+				})
+				So(err, ShouldBeNil)
+				So(mappedEventsChannel, ShouldHaveLength, 2)
+
+				So(<-mappedEventsChannel, ShouldEqual, *secondEvent.ID)
+				So(<-mappedEventsChannel, ShouldEqual, *thirdEvent.ID)
+
+			})
+
+		})
+
+		Convey("count", func() {
+
+			// create db
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			// create event repository
+			eventRepository := NewEventRepository(db.Collection("events"))
+
+			// persist events
+			firstEvent := &Event{}
+			So(eventRepository.Save(firstEvent), ShouldBeNil)
+			So(eventRepository.Save(&Event{}), ShouldBeNil)
+
+			// count all events
+			count, err := eventRepository.Count(Query{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			// count the events after the first event
+			count, err = eventRepository.Count(Query{After: firstEvent.ID})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+		})
+
+		Convey("query", func() {
+
+			// create db
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			// create event repository
+			eventRepository := NewEventRepository(db.Collection("events"))
+
+			// persist events
+			userCreated := &Event{Name: "user.created", OccurredAt: 100}
+			So(eventRepository.Save(userCreated), ShouldBeNil)
+
+			userUpdated := &Event{Name: "user.updated", OccurredAt: 200}
+			So(eventRepository.Save(userUpdated), ShouldBeNil)
+
+			userDeleted := &Event{Name: "user.deleted", OccurredAt: 300}
+			So(eventRepository.Save(userDeleted), ShouldBeNil)
+
+			// collect the ids of the events matching the query
+			mappedEvents := func(query Query) []primitive.ObjectID {
+				ids := []primitive.ObjectID{}
+				So(eventRepository.Map(query, func(e Event) {
+					ids = append(ids, *e.ID)
+				}), ShouldBeNil)
+				return ids
+			}
+
+			Convey("filter by event names", func() {
+				So(mappedEvents(Query{Names: []string{"user.created", "user.deleted"}}), ShouldResemble, []primitive.ObjectID{*userCreated.ID, *userDeleted.ID})
+			})
+
+			Convey("filter by time range", func() {
+				So(mappedEvents(Query{OccurredFrom: 200}), ShouldResemble, []primitive.ObjectID{*userUpdated.ID, *userDeleted.ID})
+				So(mappedEvents(Query{OccurredTo: 200}), ShouldResemble, []primitive.ObjectID{*userCreated.ID, *userUpdated.ID})
+				So(mappedEvents(Query{OccurredFrom: 150, OccurredTo: 250}), ShouldResemble, []primitive.ObjectID{*userUpdated.ID})
+			})
+
+			Convey("filter up to a given event", func() {
+				So(mappedEvents(Query{Until: userUpdated.ID}), ShouldResemble, []primitive.ObjectID{*userCreated.ID, *userUpdated.ID})
+				So(mappedEvents(Query{After: userCreated.ID, Until: userUpdated.ID}), ShouldResemble, []primitive.ObjectID{*userUpdated.ID})
+			})
+
 		})
 
 	})
@@ -0,0 +1,49 @@
+package es
+
+import (
+	"context"
+	"time"
+)
+
+// Context the projectors and reactors handle an event with. It carries the values of the context the event got
+// committed with, but is only cancelled together with the processor - the commit might be long gone by then.
+type eventContext struct {
+	processor context.Context
+	commit    context.Context
+}
+
+func (c *eventContext) Deadline() (time.Time, bool) {
+	return c.processor.Deadline()
+}
+
+func (c *eventContext) Done() <-chan struct{} {
+	return c.processor.Done()
+}
+
+func (c *eventContext) Err() error {
+	return c.processor.Err()
+}
+
+func (c *eventContext) Value(key interface{}) interface{} {
+
+	if value := c.commit.Value(key); value != nil {
+		return value
+	}
+
+	return c.processor.Value(key)
+
+}
+
+func newEventContext(processor context.Context, commit context.Context) context.Context {
+
+	// events that are caught up from the event store don't have a commit context
+	if commit == nil {
+		return processor
+	}
+
+	return &eventContext{
+		processor: processor,
+		commit:    commit,
+	}
+
+}
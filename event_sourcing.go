@@ -1,22 +1,69 @@
 package es
 
 import (
+	"context"
+	"errors"
 	"github.com/florianlenz/event-sourcing-go/event"
 	"github.com/florianlenz/event-sourcing-go/projector"
 	"github.com/florianlenz/event-sourcing-go/reactor"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
 	"sync"
 	"time"
 )
 
+// returned when committing events after the event sourcing got shut down
+var ErrShutdown = errors.New("event sourcing has been shut down")
+
 type EventSourcing struct {
-	eventRepository event.IEventRepository
-	close           chan struct{}
-	processor       *Processor
-	eventRegistry   *event.Registry
+	eventRepository   event.IEventRepository
+	close             chan struct{}
+	processor         *Processor
+	eventRegistry     *event.Registry
+	projectorRegistry *projector.Registry
+	logger            ILogger
+	db                *mongo.Database
+	deadLetters       deadLetterRepository
+	// guards the close channel, the in flight commits and the pending idempotent commits
+	lock    *sync.Mutex
+	commits *sync.WaitGroup
+	// idempotent commits that haven't been processed yet (by idempotency key)
+	pending map[string]*pendingCommit
+}
+
+// an idempotent commit that is in flight
+type pendingCommit struct {
+	// closed once the event got queued or the commit failed
+	ready chan struct{}
+	// handle of the committed event (nil if the commit failed)
+	handle *CommitHandle
 }
 
-func (es *EventSourcing) Commit(e event.IESEvent) (*sync.WaitGroup, error) {
+func (es *EventSourcing) Commit(e event.IESEvent, options ...CommitOption) (*CommitHandle, error) {
+	return es.CommitContext(context.Background(), e, options...)
+}
+
+// Commit the event while respecting the deadline and cancellation of the context. In case the context is done after
+// the event got persisted, the event is processed by the catch up of the processor and the context error is returned.
+// When the event queue is full the configured QueueFullPolicy is applied.
+// The returned handle can be used to wait for the result of the projectors and reactors.
+func (es *EventSourcing) CommitContext(ctx context.Context, e event.IESEvent, options ...CommitOption) (*CommitHandle, error) {
+
+	commitConfig := newCommitConfig(options...)
+
+	// reject commits once we are shutting down
+	es.lock.Lock()
+	select {
+	case <-es.close:
+		es.lock.Unlock()
+		return nil, ErrShutdown
+	default:
+	}
+	es.commits.Add(1)
+	es.lock.Unlock()
+	defer func() {
+		es.commits.Done()
+	}()
 
 	// @todo fetch event name based on type
 	eventName, err := es.eventRegistry.GetEventName(e)
@@ -30,31 +77,164 @@ func (es *EventSourcing) Commit(e event.IESEvent) (*sync.WaitGroup, error) {
 		return nil, err
 	}
 
+	// reserve the idempotency key before persisting the event - a concurrent commit with the same key must get the
+	// handle of this commit instead of a handle of an event that seems to be processed already
+	var handle *CommitHandle
+	if commitConfig.idempotencyKey != "" {
+		pending, original, err := es.reservePending(ctx, commitConfig.idempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if original != nil {
+			return original, nil
+		}
+		defer func() {
+			es.resolvePending(commitConfig.idempotencyKey, pending, handle)
+		}()
+	}
+
+	// don't persist the event if we can't queue it - a persisted event is always processed, so failing afterwards would
+	// make the caller commit it again
+	if es.processor.config.queueFullPolicy == QueueFullFailFast && es.processor.queueFull() {
+		return nil, ErrQueueFull
+	}
+
 	// new event
 	eventToPersist := &event.Event{
-		Name:       eventName,
-		Payload:    eventPayload,
-		Version:    e.Version(),
-		OccurredAt: time.Now().Unix(),
+		Name:           eventName,
+		Payload:        eventPayload,
+		Version:        e.Version(),
+		OccurredAt:     time.Now().Unix(),
+		IdempotencyKey: commitConfig.idempotencyKey,
 	}
 
-	// persist event
-	if err := es.eventRepository.Save(eventToPersist); err != nil {
+	// persist event - in case the event got committed before we return the handle of the original event
+	err = es.eventRepository.SaveContext(ctx, eventToPersist)
+	if err == event.ErrDuplicateIdempotencyKey {
+		return es.committed(ctx, commitConfig.idempotencyKey)
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	// wait group
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
+	// queue the event
+	processEvent, err := es.processor.enqueue(ctx, *eventToPersist.ID, es.processor.config.queueFullPolicy)
+	if err == ErrShutdown {
+		return nil, err
+	}
+
+	// the event is processed even if it couldn't be queued in time - a retry must get its handle
+	handle = newCommitHandle(processEvent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return handle, nil
+
+}
+
+// handle of the event that got committed with the given idempotency key before it got reserved
+func (es *EventSourcing) committed(ctx context.Context, idempotencyKey string) (*CommitHandle, error) {
+
+	original, err := es.eventRepository.FetchByIdempotencyKey(ctx, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommittedHandle(*original.ID), nil
+
+}
+
+// Reserve the idempotency key for a commit. In case another commit with the same key is in flight its handle is
+// returned instead (once its event got queued). A failed commit releases the key for the next one.
+func (es *EventSourcing) reservePending(ctx context.Context, idempotencyKey string) (*pendingCommit, *CommitHandle, error) {
+
+	for {
+
+		es.lock.Lock()
+		original, exists := es.pending[idempotencyKey]
+		if !exists {
+			pending := &pendingCommit{
+				ready: make(chan struct{}),
+			}
+			es.pending[idempotencyKey] = pending
+			es.lock.Unlock()
+			return pending, nil, nil
+		}
+		es.lock.Unlock()
+
+		select {
+		case <-original.ready:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+
+		if original.handle != nil {
+			return nil, newDuplicateCommitHandle(original.handle), nil
+		}
+
+	}
+
+}
+
+// Hand the result of the commit over to the commits waiting for it. The reservation is kept till the event got
+// processed, a failed commit (nil handle) releases it right away.
+func (es *EventSourcing) resolvePending(idempotencyKey string, pending *pendingCommit, handle *CommitHandle) {
+
+	pending.handle = handle
+
+	release := func() {
+		es.lock.Lock()
+		delete(es.pending, idempotencyKey)
+		es.lock.Unlock()
+	}
+
+	if handle == nil {
+		release()
+		close(pending.ready)
+		return
+	}
+
+	close(pending.ready)
 
 	go func() {
-		// wait till event got processed
-		<-es.processor.Process(*eventToPersist.ID)
-		// send processed signal to the passed onProcessed channel
-		wg.Done()
+		<-handle.processed
+		release()
 	}()
 
-	return wg, nil
+}
+
+// the events the projectors and reactors couldn't handle (oldest first)
+func (es *EventSourcing) DeadLetters() ([]DeadLetter, error) {
+	return es.deadLetters.All()
+}
+
+// Hand the event of the dead letter over again to the projector or reactor that failed to handle it. The dead letter
+// is discarded once the event got handled, otherwise it's updated with the new error and the error is returned.
+func (es *EventSourcing) RetryDeadLetter(id primitive.ObjectID) error {
+
+	letter, err := es.deadLetters.FetchByID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := es.processor.retry(letter); err != nil {
+		return err
+	}
+
+	return es.deadLetters.Delete(id)
+
+}
+
+// discard the dead letter without handling its event again
+func (es *EventSourcing) DiscardDeadLetter(id primitive.ObjectID) error {
+
+	if _, err := es.deadLetters.FetchByID(id); err != nil {
+		return err
+	}
+
+	return es.deadLetters.Delete(id)
 
 }
 
@@ -62,27 +242,91 @@ func (es *EventSourcing) Start() {
 	es.processor.Start()
 }
 
+// Stop accepting commits and wait till the events that are in flight got processed by the projectors and reactors.
+// The checkpoints of the projectors are persisted by then. Returns the context error in case the context expires before.
+func (es *EventSourcing) Shutdown(ctx context.Context) error {
+
+	es.lock.Lock()
+	select {
+	case <-es.close:
+	default:
+		close(es.close)
+	}
+	es.lock.Unlock()
+
+	// wait for the commits that are persisting their events right now
+	committed := make(chan struct{})
+	go func() {
+		es.commits.Wait()
+		close(committed)
+	}()
+
+	select {
+	case <-committed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return es.processor.Shutdown(ctx)
+
+}
+
+// Replay events while the application is live. The processor buffers the committed events till the replay is done and
+// then continues with the events that were committed after the last replayed event.
+func (es *EventSourcing) Replay(options ...ReplayOption) <-chan error {
+
+	// pause the processor once the replay got the lock and before it touches the projectors
+	options = append(options, func(config *replayConfig) {
+		config.onLocked = es.processor.pause
+		config.projectorRepository = es.processor.config.projectorRepository
+	})
+
+	return Replay(es.logger, es.db, es.projectorRegistry, es.eventRegistry, options...)
+
+}
+
 // create a new event sourcing instance. Don't forget to start it. The processor won't process till you told him to do so.
-func NewEventSourcing(logger ILogger, db *mongo.Database, projectorRegistry *projector.Registry, eventRegistry *event.Registry, reactorRegistry *reactor.Registry) *EventSourcing {
+func NewEventSourcing(logger ILogger, db *mongo.Database, projectorRegistry *projector.Registry, eventRegistry *event.Registry, reactorRegistry *reactor.Registry, options ...Option) *EventSourcing {
 
 	closeChan := make(chan struct{})
 
 	// collections
 	eventCollection := db.Collection("events")
 	projectorCollection := db.Collection("projectors")
+	lockCollection := db.Collection("locks")
+	deadLetterCollection := db.Collection("dead_letters")
+	outboxCollection := db.Collection("outbox")
 
 	// repos
 	eventRepository := event.NewEventRepository(eventCollection)
 	projectorRepository := projector.NewProjectorRepository(eventCollection, projectorCollection, eventRegistry)
+	reactorCheckpointRepository := newReactorCheckpointRepository(db, eventRegistry)
+	lockRepository := newReplayLockRepository(lockCollection)
+	deadLetterRepository := newDeadLetterRepository(deadLetterCollection)
+	outboxRepository := newOutboxRepository(outboxCollection)
+
+	// the checkpoints of the projectors might be kept somewhere else
+	config := newConfig(options...)
+	var liveProjectorRepository projector.IProjectorRepository = projectorRepository
+	if config.projectorRepository != nil {
+		liveProjectorRepository = config.projectorRepository
+	}
 
 	// processor
-	processor := newProcessor(projectorRegistry, eventRegistry, reactorRegistry, projectorRepository, eventRepository, logger, false)
+	processor := newProcessor(projectorRegistry, eventRegistry, reactorRegistry, liveProjectorRepository, reactorCheckpointRepository, eventRepository, lockRepository, deadLetterRepository, outboxRepository, logger, false, config)
 
 	es := &EventSourcing{
-		eventRepository: eventRepository,
-		close:           closeChan,
-		processor:       processor,
-		eventRegistry:   eventRegistry,
+		eventRepository:   eventRepository,
+		close:             closeChan,
+		processor:         processor,
+		deadLetters:       deadLetterRepository,
+		eventRegistry:     eventRegistry,
+		projectorRegistry: projectorRegistry,
+		logger:            logger,
+		db:                db,
+		lock:              &sync.Mutex{},
+		commits:           &sync.WaitGroup{},
+		pending:           map[string]*pendingCommit{},
 	}
 
 	return es
@@ -15,20 +15,38 @@ import (
 
 // test event repository
 type testEventRepository struct {
-	save      func(event *event.Event) error
-	fetchByID func(id primitive.ObjectID) (event.Event, error)
-	cb        func(eventID primitive.ObjectID)
+	save                  func(event *event.Event) error
+	fetchByID             func(id primitive.ObjectID) (event.Event, error)
+	cb                    func(event event.Event)
+	count                 func(query event.Query) (int64, error)
+	mapEvents             func(query event.Query, cb func(event event.Event)) error
+	fetchByIdempotencyKey func(key string) (event.Event, error)
 }
 
-func (r testEventRepository) Map(cb func(eventID primitive.ObjectID)) error {
+func (r testEventRepository) Map(query event.Query, cb func(event event.Event)) error {
+	if r.mapEvents != nil {
+		return r.mapEvents(query, cb)
+	}
 	r.cb = cb
 	return nil
 }
 
+func (r *testEventRepository) Count(query event.Query) (int64, error) {
+	return r.count(query)
+}
+
 func (r *testEventRepository) Save(event *event.Event) error {
 	return r.save(event)
 }
 
+func (r *testEventRepository) SaveContext(ctx context.Context, event *event.Event) error {
+	return r.save(event)
+}
+
+func (r *testEventRepository) FetchByIdempotencyKey(ctx context.Context, key string) (event.Event, error) {
+	return r.fetchByIdempotencyKey(key)
+}
+
 func (r *testEventRepository) FetchByID(id primitive.ObjectID) (event.Event, error) {
 	return r.fetchByID(id)
 }
@@ -129,6 +147,148 @@ func TestEventSourcing(t *testing.T) {
 
 		})
 
+		Convey("commit with an already used idempotency key must return the handle of the original event", func() {
+
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			eventRegistry := event.NewEventRegistry()
+			So(eventRegistry.RegisterEvent("test.event", testEvent{}), ShouldBeNil)
+
+			// create event sourcing
+			es := NewEventSourcing(nil, db, projector.NewProjectorRegistry(), eventRegistry, reactor.NewReactorRegistry())
+
+			// the first commit persists the event, the others fail with a duplicate key error
+			originalEventID := primitive.NewObjectID()
+			saved := false
+			es.eventRepository = &testEventRepository{
+				save: func(e *event.Event) error {
+					So(e.IdempotencyKey, ShouldEqual, "request-1")
+					if saved {
+						return event.ErrDuplicateIdempotencyKey
+					}
+					saved = true
+					e.ID = &originalEventID
+					return nil
+				},
+				fetchByIdempotencyKey: func(key string) (event.Event, error) {
+					return event.Event{
+						ID:             &originalEventID,
+						IdempotencyKey: key,
+					}, nil
+				},
+			}
+
+			original, err := es.Commit(testEvent{}, WithIdempotencyKey("request-1"))
+			So(err, ShouldBeNil)
+			So(original.Duplicate(), ShouldBeFalse)
+
+			// the original event is still pending
+			retried, err := es.Commit(testEvent{}, WithIdempotencyKey("request-1"))
+			So(err, ShouldBeNil)
+			So(retried.Duplicate(), ShouldBeTrue)
+			So(retried.EventID(), ShouldEqual, originalEventID)
+			So(retried.report, ShouldEqual, original.report)
+
+			// the original event got processed in the meantime
+			es.lock.Lock()
+			delete(es.pending, "request-1")
+			es.lock.Unlock()
+			retried, err = es.Commit(testEvent{}, WithIdempotencyKey("request-1"))
+			So(err, ShouldBeNil)
+			So(retried.Duplicate(), ShouldBeTrue)
+			So(retried.EventID(), ShouldEqual, originalEventID)
+
+		})
+
+		Convey("concurrent commits with the same idempotency key must wait for the original commit", func() {
+
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			eventRegistry := event.NewEventRegistry()
+			So(eventRegistry.RegisterEvent("test.event", testEvent{}), ShouldBeNil)
+
+			// create event sourcing
+			es := NewEventSourcing(nil, db, projector.NewProjectorRegistry(), eventRegistry, reactor.NewReactorRegistry())
+
+			// the original commit blocks while persisting the event
+			originalEventID := primitive.NewObjectID()
+			saving := make(chan struct{})
+			releaseSave := make(chan struct{})
+			es.eventRepository = &testEventRepository{
+				save: func(e *event.Event) error {
+					close(saving)
+					<-releaseSave
+					e.ID = &originalEventID
+					return nil
+				},
+			}
+
+			originalHandle := make(chan *CommitHandle, 1)
+			go func() {
+				handle, _ := es.Commit(testEvent{}, WithIdempotencyKey("request-1"))
+				originalHandle <- handle
+			}()
+			<-saving
+
+			retriedHandle := make(chan *CommitHandle, 1)
+			go func() {
+				handle, _ := es.Commit(testEvent{}, WithIdempotencyKey("request-1"))
+				retriedHandle <- handle
+			}()
+
+			// the retry must not report the event as processed while the original commit is still in flight
+			select {
+			case <-retriedHandle:
+				So("retry didn't wait for the original commit", ShouldBeEmpty)
+			case <-time.After(time.Millisecond * 50):
+			}
+
+			close(releaseSave)
+			original := <-originalHandle
+			retried := <-retriedHandle
+			So(retried.Duplicate(), ShouldBeTrue)
+			So(retried.EventID(), ShouldEqual, originalEventID)
+			So(retried.report, ShouldEqual, original.report)
+
+		})
+
+		Convey("a failed commit must release the idempotency key", func() {
+
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			eventRegistry := event.NewEventRegistry()
+			So(eventRegistry.RegisterEvent("test.event", testEvent{}), ShouldBeNil)
+
+			// create event sourcing
+			es := NewEventSourcing(nil, db, projector.NewProjectorRegistry(), eventRegistry, reactor.NewReactorRegistry())
+
+			// persisting the event fails the first time
+			eventID := primitive.NewObjectID()
+			attempts := 0
+			es.eventRepository = &testEventRepository{
+				save: func(e *event.Event) error {
+					attempts++
+					if attempts == 1 {
+						return errors.New("connection reset")
+					}
+					e.ID = &eventID
+					return nil
+				},
+			}
+
+			_, err = es.Commit(testEvent{}, WithIdempotencyKey("request-1"))
+			So(err, ShouldBeError, "connection reset")
+
+			retried, err := es.Commit(testEvent{}, WithIdempotencyKey("request-1"))
+			So(err, ShouldBeNil)
+			So(retried.Duplicate(), ShouldBeFalse)
+			So(retried.EventID(), ShouldEqual, eventID)
+
+		})
+
 		Convey("ensure that projector waiting group is decreased once the event got processed", func() {
 
 			// db
@@ -153,7 +313,7 @@ func TestEventSourcing(t *testing.T) {
 			done, err := es.Commit(testEvent{})
 			So(err, ShouldBeNil)
 
-			done.Wait()
+			done.Wait(context.Background())
 
 		})
 
@@ -0,0 +1,68 @@
+package es
+
+import (
+	"errors"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"time"
+)
+
+// returned when a reactor emits a follow up event beyond the maximum causation depth
+var ErrCausationLoop = errors.New("follow up event exceeds the maximum causation depth - the reactors probably trigger each other in a cycle")
+
+// Commit the follow up events of a reactor. The event the reactor reacted on is their causation, the correlation is
+// taken over from it. The follow up events are idempotent - committing them again (e.g. when the reactor reacts on the
+// event again after a crash) has no effect.
+func (p *Processor) commitFollowUps(reactorName string, cause event.Event, followUps []event.IESEvent) error {
+
+	if cause.CausationDepth+1 > p.config.maxCausationDepth {
+		return ErrCausationLoop
+	}
+
+	// make sure all follow up events can be committed before committing the first one
+	followUpEvents := []*event.Event{}
+	for i, followUp := range followUps {
+
+		eventName, err := p.eventRegistry.GetEventName(followUp)
+		if err != nil {
+			return err
+		}
+
+		eventPayload, err := event.PayloadToMap(followUp)
+		if err != nil {
+			return err
+		}
+
+		followUpEvents = append(followUpEvents, &event.Event{
+			Name:           eventName,
+			Payload:        eventPayload,
+			Version:        followUp.Version(),
+			OccurredAt:     time.Now().Unix(),
+			IdempotencyKey: fmt.Sprintf("%s:%s:%d", cause.ID.Hex(), reactorName, i),
+			CausationID:    cause.ID,
+			CorrelationID:  cause.Correlation(),
+			CausationDepth: cause.CausationDepth + 1,
+		})
+
+	}
+
+	for _, followUpEvent := range followUpEvents {
+
+		// the follow up event got committed before
+		err := p.eventRepository.Save(followUpEvent)
+		if err == event.ErrDuplicateIdempotencyKey {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := p.enqueueFollowUp(*followUpEvent.ID); err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
@@ -1,5 +1,7 @@
 module github.com/florianlenz/event-sourcing-go
 
+go 1.18
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
@@ -0,0 +1,39 @@
+package dispatch
+
+import (
+	"github.com/florianlenz/event-sourcing-go/event"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Check if the method name is the name of an event handler - 'On' followed by an uppercase letter (e.g.
+// 'OnUserCreated'). Methods like 'Online' or 'Options' aren't handlers.
+func IsOnMethod(name string) bool {
+
+	if !strings.HasPrefix(name, "On") {
+		return false
+	}
+
+	next, _ := utf8.DecodeRuneInString(name[2:])
+	return unicode.IsUpper(next)
+
+}
+
+var iesEventType = reflect.TypeOf((*event.IESEvent)(nil)).Elem()
+
+// Check if the method expects an event. 'On' methods that don't (e.g. 'OnCall() bool') are helpers instead of
+// handlers - the others must have the signature of a handler.
+func TakesEvent(method reflect.Method) bool {
+
+	// the receiver is counted as parameter too
+	for i := 1; i < method.Type.NumIn(); i++ {
+		if method.Type.In(i).Implements(iesEventType) {
+			return true
+		}
+	}
+
+	return false
+
+}
@@ -0,0 +1,64 @@
+package dispatch
+
+import (
+	"context"
+	"github.com/florianlenz/event-sourcing-go/event"
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"testing"
+)
+
+func TestIsOnMethod(t *testing.T) {
+
+	Convey("only 'On' followed by an uppercase letter names a handler", t, func() {
+
+		So(IsOnMethod("OnUserCreated"), ShouldBeTrue)
+		So(IsOnMethod("OnÜbersetzt"), ShouldBeTrue)
+
+		So(IsOnMethod("On"), ShouldBeFalse)
+		So(IsOnMethod("Online"), ShouldBeFalse)
+		So(IsOnMethod("Options"), ShouldBeFalse)
+		So(IsOnMethod("On_user_created"), ShouldBeFalse)
+		So(IsOnMethod("Handle"), ShouldBeFalse)
+
+	})
+
+}
+
+type testEvent struct {
+	event.ESEvent
+}
+
+type testTarget struct {
+}
+
+func (t *testTarget) OnEvent(e testEvent) {}
+
+func (t *testTarget) OnEventAfterContext(ctx context.Context, e *testEvent) string {
+	return ""
+}
+
+func (t *testTarget) OnCall() bool {
+	return true
+}
+
+func (t *testTarget) OnMessage(message string) {}
+
+func TestTakesEvent(t *testing.T) {
+
+	Convey("only methods that expect an event are handlers", t, func() {
+
+		takesEvent := func(name string) bool {
+			method, _ := reflect.TypeOf(&testTarget{}).MethodByName(name)
+			return TakesEvent(method)
+		}
+
+		So(takesEvent("OnEvent"), ShouldBeTrue)
+		So(takesEvent("OnEventAfterContext"), ShouldBeTrue)
+
+		So(takesEvent("OnCall"), ShouldBeFalse)
+		So(takesEvent("OnMessage"), ShouldBeFalse)
+
+	})
+
+}
@@ -0,0 +1,31 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// Run fn and retry it the given amount of times with exponential backoff (starting with the given backoff) till it
+// succeeds. Waiting for the next attempt stops once the context is done. The error of the last attempt is returned.
+func Do(ctx context.Context, retries int, backoff time.Duration, fn func() error) error {
+
+	err := fn()
+
+	for retry := 0; err != nil && retry < retries; retry++ {
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+		err = fn()
+
+	}
+
+	return err
+
+}
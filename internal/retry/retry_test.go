@@ -0,0 +1,58 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+
+	Convey("retry", t, func() {
+
+		Convey("retry with exponential backoff", func() {
+
+			attempts := []time.Time{}
+			err := Do(context.Background(), 2, time.Millisecond*10, func() error {
+				attempts = append(attempts, time.Now())
+				return errors.New("failed")
+			})
+			So(err, ShouldBeError, "failed")
+			So(attempts, ShouldHaveLength, 3)
+			So(attempts[1].Sub(attempts[0]), ShouldBeGreaterThanOrEqualTo, time.Millisecond*10)
+			So(attempts[2].Sub(attempts[1]), ShouldBeGreaterThanOrEqualTo, time.Millisecond*20)
+
+		})
+
+		Convey("stop retrying once it succeeded", func() {
+
+			attempts := 0
+			So(Do(context.Background(), 2, time.Millisecond, func() error {
+				attempts++
+				return nil
+			}), ShouldBeNil)
+			So(attempts, ShouldEqual, 1)
+
+		})
+
+		Convey("stop waiting for the next attempt once the context is done", func() {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			attempts := 0
+			start := time.Now()
+			err := Do(ctx, 2, time.Hour, func() error {
+				attempts++
+				cancel()
+				return errors.New("failed")
+			})
+			So(err, ShouldBeError, "failed")
+			So(attempts, ShouldEqual, 1)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		})
+
+	})
+
+}
@@ -0,0 +1,292 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/projector"
+	"github.com/florianlenz/event-sourcing-go/reactor"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+	"sync"
+	"time"
+)
+
+// a message of a reactor that waits to be delivered by the outbox relay
+type OutboxMessage struct {
+	// derived from the event, the reactor and the position of the message - storing a message twice has no effect
+	ID      string                 `bson:"_id"`
+	EventID primitive.ObjectID     `bson:"event_id"`
+	Reactor string                 `bson:"reactor"`
+	Topic   string                 `bson:"topic"`
+	Payload map[string]interface{} `bson:"payload"`
+	// amount of failed delivery attempts
+	Attempts  int    `bson:"attempts"`
+	LastError string `bson:"last_error"`
+	// unix timestamps - sent at is 0 as long as the message wasn't delivered
+	CreatedAt     int64 `bson:"created_at"`
+	NextAttemptAt int64 `bson:"next_attempt_at"`
+	SentAt        int64 `bson:"sent_at"`
+}
+
+type outboxRepository interface {
+	// store the messages - messages that are already stored are left untouched
+	Save(messages []OutboxMessage) error
+	// store the messages within the transaction
+	SaveInTransaction(tx projector.ITransaction, messages []OutboxMessage) error
+	// messages that are due for delivery (oldest first)
+	Due(now time.Time, limit int) ([]OutboxMessage, error)
+	// mark the message as delivered
+	Sent(id string, sentAt time.Time) error
+	// record a failed delivery attempt
+	Failed(id string, err error, nextAttemptAt time.Time) error
+	// create the outbox in case it doesn't exist yet - must be done before messages are stored within a transaction
+	CreateIndexes() error
+}
+
+type mongoOutboxRepository struct {
+	outboxCollection *mongo.Collection
+	lock             *sync.Mutex
+	// set once the indexes (and with them the collection) got created
+	indexesCreated bool
+}
+
+// Create the index of the due messages. This creates the outbox collection as well - mongo < 4.4 can't create
+// collections within a transaction.
+func (r *mongoOutboxRepository) CreateIndexes() error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	if r.indexesCreated {
+		return nil
+	}
+
+	indexOptions := options.Index()
+	indexOptions.SetName("due")
+
+	_, err := r.outboxCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "sent_at", Value: 1}, {Key: "next_attempt_at", Value: 1}, {Key: "created_at", Value: 1}},
+		Options: indexOptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.indexesCreated = true
+
+	return nil
+
+}
+
+func (r *mongoOutboxRepository) Save(messages []OutboxMessage) error {
+
+	if err := r.CreateIndexes(); err != nil {
+		return err
+	}
+
+	return r.save(context.Background(), messages)
+
+}
+
+func (r *mongoOutboxRepository) SaveInTransaction(tx projector.ITransaction, messages []OutboxMessage) error {
+
+	mongoTransaction, k := tx.(*projector.MongoTransaction)
+	if !k {
+		return errors.New("the outbox only supports mongo transactions")
+	}
+
+	return r.save(mongoTransaction.Session, messages)
+
+}
+
+func (r *mongoOutboxRepository) save(ctx context.Context, messages []OutboxMessage) error {
+
+	// create message if it doesn't exist
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	for _, message := range messages {
+
+		_, err := r.outboxCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": message.ID},
+			bson.M{
+				"$setOnInsert": bson.M{
+					"event_id":        message.EventID,
+					"reactor":         message.Reactor,
+					"topic":           message.Topic,
+					"payload":         message.Payload,
+					"attempts":        0,
+					"last_error":      "",
+					"created_at":      message.CreatedAt,
+					"next_attempt_at": message.NextAttemptAt,
+					"sent_at":         0,
+				},
+			},
+			updateOptions,
+		)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+func (r *mongoOutboxRepository) Due(now time.Time, limit int) ([]OutboxMessage, error) {
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"created_at": 1})
+	findOptions.SetLimit(int64(limit))
+
+	cursor, err := r.outboxCollection.Find(
+		context.Background(),
+		bson.M{
+			"sent_at":         0,
+			"next_attempt_at": bson.M{"$lte": now.Unix()},
+		},
+		findOptions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	messages := []OutboxMessage{}
+	for cursor.Next(ctx) {
+		message := OutboxMessage{}
+		if err := cursor.Decode(&message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, cursor.Close(ctx)
+
+}
+
+func (r *mongoOutboxRepository) Sent(id string, sentAt time.Time) error {
+
+	_, err := r.outboxCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{
+				"sent_at": sentAt.Unix(),
+			},
+		},
+	)
+
+	return err
+
+}
+
+func (r *mongoOutboxRepository) Failed(id string, err error, nextAttemptAt time.Time) error {
+
+	_, updateErr := r.outboxCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{
+				"last_error":      err.Error(),
+				"next_attempt_at": nextAttemptAt.Unix(),
+			},
+			"$inc": bson.M{
+				"attempts": 1,
+			},
+		},
+	)
+
+	return updateErr
+
+}
+
+func newOutboxRepository(outboxCollection *mongo.Collection) *mongoOutboxRepository {
+	return &mongoOutboxRepository{
+		outboxCollection: outboxCollection,
+		lock:             &sync.Mutex{},
+	}
+}
+
+// turn the messages of the reactor into outbox messages
+func outboxMessages(reactorName string, persistedEvent event.Event, messages []reactor.Message) []OutboxMessage {
+
+	now := time.Now().Unix()
+
+	outboxMessages := []OutboxMessage{}
+	for i, message := range messages {
+		outboxMessages = append(outboxMessages, OutboxMessage{
+			ID:            fmt.Sprintf("%s:%s:%d", persistedEvent.ID.Hex(), reactorName, i),
+			EventID:       *persistedEvent.ID,
+			Reactor:       reactorName,
+			Topic:         message.Topic,
+			Payload:       message.Payload,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		})
+	}
+
+	return outboxMessages
+
+}
+
+// Store the messages of the reactor and move its checkpoint. Both are written in one transaction in case the
+// checkpoint repository supports transactions.
+func (p *Processor) storeOutbox(reactorName string, persistedEvent event.Event, messages []reactor.Message, updateCheckpoint bool) error {
+
+	if p.outboxRepository == nil {
+		return errors.New("there is no outbox the messages of the reactor could be stored in")
+	}
+
+	outboxMessages := outboxMessages(reactorName, persistedEvent, messages)
+
+	transactionalRepository, k := p.reactorCheckpointRepository.(projector.ITransactionalProjectorRepository)
+	if !k {
+		if err := p.outboxRepository.Save(outboxMessages); err != nil {
+			return err
+		}
+		if updateCheckpoint {
+			p.updateReactorCheckpoint(reactorName, persistedEvent)
+		}
+		return nil
+	}
+
+	// the repository of the checkpoints creates its collection before it starts the transaction
+	if err := p.outboxRepository.CreateIndexes(); err != nil {
+		return err
+	}
+
+	err := transactionalRepository.InTransaction(context.Background(), func(tx projector.ITransaction) error {
+
+		if err := p.outboxRepository.SaveInTransaction(tx, outboxMessages); err != nil {
+			return err
+		}
+
+		if !updateCheckpoint {
+			return nil
+		}
+
+		return transactionalRepository.UpdateLastHandledEventInTransaction(tx, &reactorCheckpoint{reactorName: reactorName}, persistedEvent)
+
+	})
+	if err != nil {
+		return err
+	}
+
+	if updateCheckpoint {
+		p.setReactorCheckpoint(reactorName, persistedEvent.ID)
+	}
+
+	return nil
+
+}
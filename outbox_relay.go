@@ -0,0 +1,168 @@
+package es
+
+import (
+	"context"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"time"
+)
+
+// delivers the messages of the outbox to an external system
+type IOutboxPublisher interface {
+	// Deliver the message. A message is delivered at least once - it might be delivered again in case the relay crashes
+	// right after publishing it.
+	Publish(ctx context.Context, message OutboxMessage) error
+}
+
+type outboxRelayConfig struct {
+	// time to wait when there are no messages to deliver
+	pollInterval time.Duration
+	// maximum amount of messages delivered per round
+	batchSize int
+	// time to wait before retrying a failed message - doubled with every attempt till it reaches the maximum backoff
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+type OutboxRelayOption func(config *outboxRelayConfig)
+
+// time to wait for new messages when the outbox is empty (defaults to one second)
+func RelayWithPollInterval(interval time.Duration) OutboxRelayOption {
+	return func(config *outboxRelayConfig) {
+		config.pollInterval = interval
+	}
+}
+
+// maximum amount of messages delivered at once (defaults to 100)
+func RelayWithBatchSize(messages int) OutboxRelayOption {
+	return func(config *outboxRelayConfig) {
+		config.batchSize = messages
+	}
+}
+
+// time to wait before retrying a message the first time (defaults to one second). The backoff is doubled with every
+// failed attempt till it reaches the maximum (defaults to five minutes).
+func RelayWithBackoff(backoff time.Duration, maxBackoff time.Duration) OutboxRelayOption {
+	return func(config *outboxRelayConfig) {
+		config.backoff = backoff
+		config.maxBackoff = maxBackoff
+	}
+}
+
+// delivers the messages stored in the outbox by the outbox reactors
+type OutboxRelay struct {
+	outboxRepository outboxRepository
+	publisher        IOutboxPublisher
+	logger           ILogger
+	config           *outboxRelayConfig
+}
+
+// Deliver the messages of the outbox till the context is done. Failed messages are retried with exponential backoff.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+
+	for {
+
+		delivered := r.deliver(ctx)
+
+		// continue right away in case there might be more messages
+		if delivered == r.config.batchSize {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		select {
+		case <-time.After(r.config.pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	}
+
+}
+
+// deliver the messages that are due - returns the amount of messages that were due
+func (r *OutboxRelay) deliver(ctx context.Context) int {
+
+	messages, err := r.outboxRepository.Due(time.Now(), r.config.batchSize)
+	if err != nil {
+		r.logger.Error(err)
+		return 0
+	}
+
+	for _, message := range messages {
+
+		if ctx.Err() != nil {
+			return len(messages)
+		}
+
+		// a panicking publisher must not stop the relay
+		err := recovered(func() error {
+			return r.publisher.Publish(ctx, message)
+		})
+
+		if err == nil {
+			if err := r.outboxRepository.Sent(message.ID, time.Now()); err != nil {
+				r.logger.Error(err)
+			}
+			continue
+		}
+
+		r.logger.Error(err)
+		if err := r.outboxRepository.Failed(message.ID, err, time.Now().Add(r.backoff(message.Attempts))); err != nil {
+			r.logger.Error(err)
+		}
+
+	}
+
+	return len(messages)
+
+}
+
+// time to wait after the given amount of failed attempts (not counting the current one)
+func (r *OutboxRelay) backoff(failedAttempts int) time.Duration {
+
+	backoff := r.config.backoff
+	for i := 0; i < failedAttempts && backoff < r.config.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > r.config.maxBackoff {
+		return r.config.maxBackoff
+	}
+
+	return backoff
+
+}
+
+func newOutboxRelay(logger ILogger, outboxRepository outboxRepository, publisher IOutboxPublisher, options ...OutboxRelayOption) *OutboxRelay {
+
+	config := &outboxRelayConfig{
+		pollInterval: time.Second,
+		batchSize:    100,
+		backoff:      time.Second,
+		maxBackoff:   time.Minute * 5,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	if config.batchSize < 1 {
+		config.batchSize = 1
+	}
+
+	return &OutboxRelay{
+		outboxRepository: outboxRepository,
+		publisher:        publisher,
+		logger:           logger,
+		config:           config,
+	}
+
+}
+
+// Create a relay that delivers the messages the outbox reactors stored in the outbox collection of the database.
+// Run it in the background. Running multiple relays at once might deliver messages multiple times.
+func NewOutboxRelay(logger ILogger, db *mongo.Database, publisher IOutboxPublisher, options ...OutboxRelayOption) *OutboxRelay {
+	return newOutboxRelay(logger, newOutboxRepository(db.Collection("outbox")), publisher, options...)
+}
@@ -0,0 +1,262 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/projector"
+	"github.com/florianlenz/event-sourcing-go/reactor"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+// test outbox repository that keeps the messages in memory
+type testOutboxRepository struct {
+	lock     *sync.Mutex
+	messages []OutboxMessage
+}
+
+func (r *testOutboxRepository) Save(messages []OutboxMessage) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, message := range messages {
+		if r.find(message.ID) == -1 {
+			r.messages = append(r.messages, message)
+		}
+	}
+
+	return nil
+
+}
+
+func (r *testOutboxRepository) SaveInTransaction(tx projector.ITransaction, messages []OutboxMessage) error {
+	return r.Save(messages)
+}
+
+func (r *testOutboxRepository) Due(now time.Time, limit int) ([]OutboxMessage, error) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	messages := []OutboxMessage{}
+	for _, message := range r.messages {
+		if message.SentAt == 0 && message.NextAttemptAt <= now.Unix() && len(messages) < limit {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+
+}
+
+func (r *testOutboxRepository) Sent(id string, sentAt time.Time) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.messages[r.find(id)].SentAt = sentAt.Unix()
+
+	return nil
+
+}
+
+func (r *testOutboxRepository) Failed(id string, err error, nextAttemptAt time.Time) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	i := r.find(id)
+	r.messages[i].Attempts++
+	r.messages[i].LastError = err.Error()
+	r.messages[i].NextAttemptAt = nextAttemptAt.Unix()
+
+	return nil
+
+}
+
+func (r *testOutboxRepository) CreateIndexes() error {
+	return nil
+}
+
+func (r *testOutboxRepository) all() []OutboxMessage {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]OutboxMessage{}, r.messages...)
+
+}
+
+func (r *testOutboxRepository) find(id string) int {
+	for i, message := range r.messages {
+		if message.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// test publisher
+type testOutboxPublisher struct {
+	publish func(message OutboxMessage) error
+}
+
+func (p *testOutboxPublisher) Publish(ctx context.Context, message OutboxMessage) error {
+	return p.publish(message)
+}
+
+func TestOutboxRelay(t *testing.T) {
+
+	Convey("outbox relay", t, func() {
+
+		Convey("deliver the due messages and retry the failed ones with backoff", func() {
+
+			outboxRepository := &testOutboxRepository{
+				lock: &sync.Mutex{},
+			}
+			So(outboxRepository.Save([]OutboxMessage{
+				{ID: "first", Topic: "mails"},
+				{ID: "second", Topic: "mails"},
+			}), ShouldBeNil)
+
+			published := []string{}
+			relay := newOutboxRelay(&testLogger{errorChan: make(chan error, 10)}, outboxRepository, &testOutboxPublisher{
+				publish: func(message OutboxMessage) error {
+					published = append(published, message.ID)
+					if message.ID == "second" {
+						return errors.New("broker is down")
+					}
+					return nil
+				},
+			}, RelayWithBackoff(time.Minute, time.Minute*3))
+
+			So(relay.deliver(context.Background()), ShouldEqual, 2)
+			So(published, ShouldResemble, []string{"first", "second"})
+
+			messages := outboxRepository.all()
+			So(messages[0].SentAt, ShouldBeGreaterThan, 0)
+			So(messages[1].SentAt, ShouldEqual, 0)
+			So(messages[1].Attempts, ShouldEqual, 1)
+			So(messages[1].LastError, ShouldEqual, "broker is down")
+			So(messages[1].NextAttemptAt, ShouldBeGreaterThanOrEqualTo, time.Now().Add(time.Minute).Unix()-1)
+
+			// the failed message isn't due yet
+			So(relay.deliver(context.Background()), ShouldEqual, 0)
+
+			// the backoff is doubled with every attempt till it reaches the maximum
+			So(relay.backoff(0), ShouldEqual, time.Minute)
+			So(relay.backoff(1), ShouldEqual, time.Minute*2)
+			So(relay.backoff(2), ShouldEqual, time.Minute*3)
+			So(relay.backoff(10), ShouldEqual, time.Minute*3)
+
+		})
+
+		Convey("run till the context is done", func() {
+
+			outboxRepository := &testOutboxRepository{
+				lock: &sync.Mutex{},
+			}
+			So(outboxRepository.Save([]OutboxMessage{{ID: "first"}}), ShouldBeNil)
+
+			published := make(chan string, 1)
+			relay := newOutboxRelay(&testLogger{errorChan: make(chan error, 10)}, outboxRepository, &testOutboxPublisher{
+				publish: func(message OutboxMessage) error {
+					published <- message.ID
+					return nil
+				},
+			}, RelayWithPollInterval(time.Millisecond*10))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- relay.Run(ctx)
+			}()
+
+			So(<-published, ShouldEqual, "first")
+			cancel()
+			So(<-done, ShouldEqual, context.Canceled)
+
+		})
+
+	})
+
+}
+
+func TestMongoOutboxRepository(t *testing.T) {
+
+	Convey("mongo outbox repository", t, func() {
+
+		// db without an outbox
+		client, err := mongo.Connect(context.TODO(), "mongodb://localhost:8034")
+		So(err, ShouldBeNil)
+		db := client.Database("godb")
+		So(db.Drop(context.Background()), ShouldBeNil)
+
+		// processor that stores the messages together with the checkpoints of the reactors
+		outboxRepository := newOutboxRepository(db.Collection("outbox"))
+		processor := &Processor{
+			outboxRepository:            outboxRepository,
+			reactorCheckpointRepository: newReactorCheckpointRepository(db, event.NewEventRegistry()),
+			reactorCheckpoints:          map[string]*primitive.ObjectID{},
+			reactorCheckpointsLock:      &sync.Mutex{},
+		}
+
+		eventID := primitive.NewObjectID()
+		persistedEvent := event.Event{ID: &eventID}
+		messages := []reactor.Message{
+			{Topic: "mails", Payload: map[string]interface{}{"to": "alice"}},
+			{Topic: "mails", Payload: map[string]interface{}{"to": "bob"}},
+		}
+
+		Convey("store the messages within the transaction of the reactor checkpoint", func() {
+
+			So(processor.storeOutbox("mailer", persistedEvent, messages, true), ShouldBeNil)
+
+			// storing the messages again has no effect
+			So(processor.storeOutbox("mailer", persistedEvent, messages, false), ShouldBeNil)
+
+			checkpoint, err := processor.reactorCheckpointRepository.LastHandledEvent(&reactorCheckpoint{reactorName: "mailer"})
+			So(err, ShouldBeNil)
+			So(*checkpoint, ShouldEqual, eventID)
+			So(*processor.reactorCheckpoint("mailer"), ShouldEqual, eventID)
+
+			due, err := outboxRepository.Due(time.Now(), 10)
+			So(err, ShouldBeNil)
+			So(due, ShouldHaveLength, 2)
+			So(due[0].ID, ShouldEqual, eventID.Hex()+":mailer:0")
+			So(due[0].EventID, ShouldEqual, eventID)
+			So(due[0].Reactor, ShouldEqual, "mailer")
+			So(due[0].Topic, ShouldEqual, "mails")
+			So(due[0].Payload, ShouldResemble, map[string]interface{}{"to": "alice"})
+
+		})
+
+		Convey("only unsent messages are due once their next attempt is reached", func() {
+
+			So(processor.storeOutbox("mailer", persistedEvent, messages, true), ShouldBeNil)
+
+			So(outboxRepository.Sent(eventID.Hex()+":mailer:0", time.Now()), ShouldBeNil)
+			So(outboxRepository.Failed(eventID.Hex()+":mailer:1", errors.New("broker is down"), time.Now().Add(time.Minute)), ShouldBeNil)
+
+			due, err := outboxRepository.Due(time.Now(), 10)
+			So(err, ShouldBeNil)
+			So(due, ShouldBeEmpty)
+
+			due, err = outboxRepository.Due(time.Now().Add(time.Minute*2), 10)
+			So(err, ShouldBeNil)
+			So(due, ShouldHaveLength, 1)
+			So(due[0].ID, ShouldEqual, eventID.Hex()+":mailer:1")
+			So(due[0].Attempts, ShouldEqual, 1)
+			So(due[0].LastError, ShouldEqual, "broker is down")
+
+		})
+
+	})
+
+}
@@ -0,0 +1,34 @@
+package es
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// a projector or reactor panicked while handling an event
+type PanicError struct {
+	// the value passed to panic
+	Value interface{}
+	// stack of the go routine that panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// call the handler and turn a panic into a PanicError so that a single handler can't take down the processor
+func recovered(handle func() error) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	return handle()
+
+}
@@ -1,153 +1,955 @@
 package es
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/florianlenz/event-sourcing-go/event"
 	"github.com/florianlenz/event-sourcing-go/projector"
 	"github.com/florianlenz/event-sourcing-go/reactor"
 	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// interval in which the processor checks if a replay got started or is done
+const replayLockPollInterval = time.Second
+
 type Processor struct {
 	stop                chan struct{}
 	projectorRegistry   *projector.Registry
+	eventRegistry       *event.Registry
+	reactorRegistry     *reactor.Registry
 	projectorRepository projector.IProjectorRepository
-	eventQueue          chan processEvent
-	start               chan struct{}
+	// stores the checkpoints of the reactors
+	reactorCheckpointRepository projector.IProjectorRepository
+	// the last event each reactor reacted on
+	reactorCheckpoints     map[string]*primitive.ObjectID
+	reactorCheckpointsLock *sync.Mutex
+	// the workers of the reactors (only accessed by the reactor go routine)
+	reactorWorkers map[string]*reactorWorker
+	// limits the amount of reactors that react at the same time
+	reactorSlots chan struct{}
+	// stores the messages of the outbox reactors
+	outboxRepository outboxRepository
+	eventRepository  event.IEventRepository
+	lockRepository   replayLockRepository
+	// records the events that couldn't be handled (optional)
+	deadLetterRepository deadLetterRepository
+	logger               ILogger
+	replay               bool
+	config               *config
+	eventQueue           chan processEvent
+	start                chan struct{}
+	started              int32
+	pauseRequests        chan chan struct{}
+	// the workers of the projectors (only accessed by the processor go routine)
+	workers   map[string]*projectorWorker
+	reactions chan reaction
+	// done once the reactors finished the reactions handed over so far - they might commit follow up events till then
+	reacting *sync.WaitGroup
+	// set while events got persisted without being queued - they are caught up from the event store
+	behind       int32
+	skippedLock  *sync.Mutex
+	firstSkipped *primitive.ObjectID
+	skipped      map[primitive.ObjectID]processEvent
+	catchUp      chan struct{}
+	// the last processed event and the last event processed by the catch up (only accessed by the processor go routine)
+	lastProcessed *primitive.ObjectID
+	caughtUpTo    *primitive.ObjectID
+	// closed once a shutdown got requested
+	shutdown     chan struct{}
+	shuttingDown int32
+	// closed once the processor shut down
+	stopped chan struct{}
+	// the workers and the reactor go routine
+	running *sync.WaitGroup
+	// dead letters that should be retried
+	retries chan deadLetterRetry
+	// the projectors and reactors handle the events with a context derived from this one. It's cancelled once the
+	// processor stopped or the shutdown stopped waiting for the in flight events.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type processEvent struct {
-	eventID     primitive.ObjectID
+	eventID primitive.ObjectID
+	// context the event got committed with (nil if the event is caught up from the event store)
+	ctx         context.Context
 	onProcessed chan struct{}
+	// result of processing the event
+	report *Report
+}
+
+type reaction struct {
+	// empty in case the event couldn't be loaded
+	persistedEvent event.Event
+	// nil in case the event couldn't be loaded
+	esEvent     event.IESEvent
+	ctx         context.Context
+	projected   *sync.WaitGroup
+	onProcessed chan struct{}
+	report      *Report
+	// only the reactor with this name reacts on the event (all reactors if empty)
+	reactor string
+	// react even if the reactors reacted on the event before (e.g. when retrying a dead letter)
+	reprocess bool
+	// mark the event as processed without waiting for the reactors
+	detached bool
+	// done once the reactors reacted on the event
+	reacted *sync.WaitGroup
+}
+
+// request to retry a dead letter - the result is sent once the event got handled
+type deadLetterRetry struct {
+	letter DeadLetter
+	result chan error
 }
 
 func (p *Processor) Stop() {
 	p.stop <- struct{}{}
+	p.cancel()
 }
 
 func (p *Processor) Process(eventID primitive.ObjectID) <-chan struct{} {
+	e, _ := p.enqueue(context.Background(), eventID, QueueFullBlock)
+	return e.onProcessed
+}
+
+// Queue the persisted event for processing. Respects the queue full policy as well as the deadline and cancellation of
+// the context. An event that couldn't be queued is processed by the catch up once there is space in the queue again.
+func (p *Processor) ProcessContext(ctx context.Context, eventID primitive.ObjectID) (<-chan struct{}, error) {
+	e, err := p.enqueue(ctx, eventID, p.config.queueFullPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return e.onProcessed, nil
+}
+
+// check if the event queue is full
+func (p *Processor) queueFull() bool {
+	return len(p.eventQueue) >= cap(p.eventQueue)
+}
+
+func (p *Processor) enqueue(ctx context.Context, eventID primitive.ObjectID, policy QueueFullPolicy) (processEvent, error) {
+
+	e := processEvent{
+		eventID:     eventID,
+		ctx:         ctx,
+		onProcessed: make(chan struct{}, 1),
+		report:      newReport(eventID),
+	}
+
+	if atomic.LoadInt32(&p.shuttingDown) == 1 {
+		return e, ErrShutdown
+	}
+
+	return e, p.queue(e, policy)
 
-	onProcessedChan := make(chan struct{}, 1)
+}
 
-	p.eventQueue <- processEvent{
+// Queue a follow up event of a reactor. Follow up events are still taken while the processor shuts down - it waits for
+// the reactors (and their follow up events) before it stops. Returns ErrShutdown once the processor stopped.
+func (p *Processor) enqueueFollowUp(eventID primitive.ObjectID) error {
+
+	select {
+	case <-p.stopped:
+		return ErrShutdown
+	default:
+	}
+
+	// the reactors must not block on the event queue - events that don't fit are caught up from the event store
+	return p.queue(processEvent{
 		eventID:     eventID,
-		onProcessed: onProcessedChan,
+		ctx:         context.Background(),
+		onProcessed: make(chan struct{}, 1),
+		report:      newReport(eventID),
+	}, QueueFullPersistOnly)
+
+}
+
+// queue the event according to the policy - events that couldn't be queued are caught up from the event store
+func (p *Processor) queue(e processEvent, policy QueueFullPolicy) error {
+
+	// events must not overtake the events that are caught up from the event store
+	if atomic.LoadInt32(&p.behind) == 1 {
+		p.skip(e)
+		return nil
+	}
+
+	// wait for space in the queue
+	if policy == QueueFullBlock {
+		select {
+		case p.eventQueue <- e:
+			return nil
+		case <-e.ctx.Done():
+			p.skip(e)
+			return e.ctx.Err()
+		}
 	}
 
-	return onProcessedChan
+	// the event is persisted already - it's caught up instead of failing (even with the QueueFullFailFast policy)
+	select {
+	case p.eventQueue <- e:
+		return nil
+	case <-e.ctx.Done():
+		p.skip(e)
+		return e.ctx.Err()
+	default:
+		p.skip(e)
+		return nil
+	}
+
+}
+
+// remember an event that got persisted but not queued and signal the processor to catch up on it
+func (p *Processor) skip(e processEvent) {
+
+	// lock
+	p.skippedLock.Lock()
+	defer func() {
+		p.skippedLock.Unlock()
+	}()
+
+	if p.firstSkipped == nil || bytes.Compare(e.eventID[:], p.firstSkipped[:]) < 0 {
+		eventID := e.eventID
+		p.firstSkipped = &eventID
+	}
+	p.skipped[e.eventID] = e
+	atomic.StoreInt32(&p.behind, 1)
+
+	select {
+	case p.catchUp <- struct{}{}:
+	default:
+	}
+
+}
+
+// Stop processing new events, process the queued events and wait till the projectors and reactors are done with them.
+// Returns the context error in case the context expires before - the processor keeps draining in the background.
+// The processor can't be used anymore after it got shut down.
+func (p *Processor) Shutdown(ctx context.Context) error {
+
+	if atomic.CompareAndSwapInt32(&p.shuttingDown, 0, 1) {
+		close(p.shutdown)
+	}
+
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		// tell the projectors and reactors that we are no longer waiting for them
+		p.cancel()
+		return ctx.Err()
+	}
 
 }
 
 // The processor will only start to work once the start method got called
 // You can't call it twice and you can't call stop and then start again.
 func (p *Processor) Start() {
+	atomic.StoreInt32(&p.started, 1)
 	p.start <- struct{}{}
 }
 
+// pause the processor till the replay lock got released. Returns once the projectors and reactors finished the events
+// that got handed over to them. Returns ErrShutdown in case the processor stopped.
+func (p *Processor) pause() error {
+
+	// there is nothing to wait for if the processor never started. It will check the replay lock once it starts.
+	if atomic.LoadInt32(&p.started) == 0 {
+		return nil
+	}
+
+	acknowledged := make(chan struct{})
+	select {
+	case p.pauseRequests <- acknowledged:
+	case <-p.stopped:
+		return ErrShutdown
+	}
+	<-acknowledged
+
+	return nil
+
+}
+
+// check if a replay is running
+func (p *Processor) replayLocked() bool {
+
+	if p.lockRepository == nil {
+		return false
+	}
+
+	lock, err := p.lockRepository.Fetch()
+	if err != nil {
+		p.logger.Error(err)
+		return false
+	}
+
+	return lock.held(time.Now())
+
+}
+
+// process the event. Projectors to which the event got already applied by the handed over replay are skipped.
+func (p *Processor) process(processEvent processEvent, handover *replayHandover) {
+
+	eventID := processEvent.eventID
+
+	// the event got already processed by the catch up
+	if p.caughtUpTo != nil && bytes.Compare(eventID[:], p.caughtUpTo[:]) <= 0 {
+		processEvent.onProcessed <- struct{}{}
+		return
+	}
+
+	// persisted event
+	persistedEvent, err := p.eventRepository.FetchByID(eventID)
+	if err != nil {
+		p.logger.Error(err)
+		processEvent.report.Error = err
+		p.handOver(reaction{
+			projected:   &sync.WaitGroup{},
+			onProcessed: processEvent.onProcessed,
+			report:      processEvent.report,
+		})
+		return
+	}
+
+	p.apply(persistedEvent, processEvent, handover)
+
+}
+
+// hand the persisted event over to the projectors and reactors
+func (p *Processor) apply(persistedEvent event.Event, processEvent processEvent, handover *replayHandover) {
+
+	p.lastProcessed = persistedEvent.ID
+
+	// the projectors and reactors get the values of the commit context
+	ctx := newEventContext(p.ctx, processEvent.ctx)
+
+	// wait group that is done once all projectors applied the event
+	projected := &sync.WaitGroup{}
+
+	// reacting on the event is the last step of processing an event
+	react := reaction{
+		ctx:            ctx,
+		persistedEvent: persistedEvent,
+		projected:      projected,
+		onProcessed:    processEvent.onProcessed,
+		report:         processEvent.report,
+		detached:       p.config.detachedReactors,
+	}
+
+	// transform persisted event to event sourcing event
+	esEvent, err := p.eventRegistry.EventToESEvent(persistedEvent)
+	if err != nil {
+		p.logger.Error(err)
+		p.deadLetter(persistedEvent, "", "", err, 1)
+		processEvent.report.Error = err
+		p.handOver(react)
+		return
+	}
+
+	// hand the event over to the workers of the projectors
+	projectors := p.projectorRegistry.ProjectorsForEvent(esEvent)
+	for _, projector := range projectors {
+
+		// the replay already applied the event
+		if handover.replayed(projector.Name(), persistedEvent) {
+			continue
+		}
+
+		projected.Add(1)
+		p.projectorWorker(projector).jobs <- projectorJob{
+			ctx:            ctx,
+			persistedEvent: persistedEvent,
+			esEvent:        esEvent,
+			done:           projected,
+			report:         processEvent.report,
+		}
+
+	}
+
+	react.esEvent = esEvent
+	p.handOver(react)
+
+}
+
+// Process the events that got persisted without being queued. The queued events are processed first since they got
+// committed before the skipped events.
+func (p *Processor) catchUpSkipped(handover *replayHandover) {
+
+	for queued := len(p.eventQueue); queued > 0; queued-- {
+		p.process(<-p.eventQueue, handover)
+	}
+
+	// take over the skipped events - events committed from now on are queued again
+	p.skippedLock.Lock()
+	firstSkipped := p.firstSkipped
+	skipped := p.skipped
+	p.firstSkipped = nil
+	p.skipped = map[primitive.ObjectID]processEvent{}
+	atomic.StoreInt32(&p.behind, 0)
+	p.skippedLock.Unlock()
+
+	if firstSkipped == nil {
+		return
+	}
+
+	// process the events starting with the first skipped event
+	err := p.eventRepository.Map(event.Query{From: firstSkipped}, func(persistedEvent event.Event) {
+
+		eventID := *persistedEvent.ID
+
+		// already processed
+		if p.lastProcessed != nil && bytes.Compare(eventID[:], p.lastProcessed[:]) <= 0 {
+			return
+		}
+
+		skippedEvent, exists := skipped[eventID]
+		if !exists {
+			skippedEvent = processEvent{
+				eventID:     eventID,
+				onProcessed: make(chan struct{}, 1),
+				report:      newReport(eventID),
+			}
+		}
+		delete(skipped, eventID)
+
+		p.apply(persistedEvent, skippedEvent, handover)
+		p.caughtUpTo = &eventID
+
+	})
+	if err != nil {
+		p.logger.Error(err)
+
+		// the next committed event triggers the next attempt
+		p.skippedLock.Lock()
+		for eventID, skippedEvent := range skipped {
+			p.skipped[eventID] = skippedEvent
+		}
+		if p.firstSkipped == nil || bytes.Compare(firstSkipped[:], p.firstSkipped[:]) < 0 {
+			p.firstSkipped = firstSkipped
+		}
+		atomic.StoreInt32(&p.behind, 1)
+		p.skippedLock.Unlock()
+
+		return
+	}
+
+	// the remaining events got processed before we caught up
+	for _, skippedEvent := range skipped {
+		skippedEvent.onProcessed <- struct{}{}
+	}
+
+}
+
+// get the worker of the projector - it's created in case it doesn't exist yet
+func (p *Processor) projectorWorker(projector projector.IProjector) *projectorWorker {
+
+	worker, exists := p.workers[projector.Name()]
+	if exists {
+		return worker
+	}
+
+	worker = newProjectorWorker(projector, p.projectorRegistry.ErrorPolicy(projector), p.config.projectorQueueSize, p.config.projectorBatchSize)
+	p.workers[projector.Name()] = worker
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		worker.run(p)
+	}()
+
+	return worker
+
+}
+
+// Make sure that the projector is not out of sync. Being out of sync by one is fine since we are about to apply the
+// event. Events that got handed over to the projector but are not applied yet are taken into account as well.
+func (p *Processor) inSync(projector projector.IProjector, persistedEvent event.Event, inFlight int64) error {
+
+	if p.replay {
+		return nil
+	}
+
+	outOfSyncBy, err := p.projectorRepository.OutOfSyncBy(projector)
+	if err != nil {
+		return err
+	}
+
+	// report if projector is out of sync
+	if outOfSyncBy > 1+inFlight {
+		return fmt.Errorf("projector '%s' is out of sync - tried to apply event with name '%s'", projector.Name(), persistedEvent.Name)
+	}
+
+	return nil
+
+}
+
+// Check if the projector already handled the event. Events are re-delivered e.g. by the catch up or by other processes.
+func (p *Processor) alreadyHandled(projector projector.IProjector, persistedEvent event.Event) (bool, error) {
+
+	if p.replay || persistedEvent.ID == nil {
+		return false, nil
+	}
+
+	lastHandledEvent, err := p.projectorRepository.LastHandledEvent(projector)
+	if err != nil || lastHandledEvent == nil {
+		return false, err
+	}
+
+	return bytes.Compare(persistedEvent.ID[:], lastHandledEvent[:]) <= 0, nil
+
+}
+
+// report the result of applying an event to the projector
+func (p *Processor) projected(projector projector.IProjector, job projectorJob, err error) {
+
+	if err != nil {
+		p.logger.Error(err)
+	}
+
+	job.report.projected(projector.Name(), err)
+	job.done.Done()
+
+}
+
+// record an event that couldn't be handled by the projector or reactor with the given name
+func (p *Processor) deadLetter(persistedEvent event.Event, projectorName string, reactorName string, err error, attempts int) {
+
+	if p.deadLetterRepository == nil || persistedEvent.ID == nil {
+		return
+	}
+
+	if err := p.deadLetterRepository.Save(newDeadLetter(persistedEvent, projectorName, reactorName, err, attempts)); err != nil {
+		p.logger.Error(err)
+	}
+
+}
+
+// Retry the dead letter and wait for the result. Returns ErrShutdown in case the processor shut down.
+func (p *Processor) retry(letter DeadLetter) error {
+
+	retry := deadLetterRetry{
+		letter: letter,
+		result: make(chan error, 1),
+	}
+
+	select {
+	case p.retries <- retry:
+	case <-p.stopped:
+		return ErrShutdown
+	}
+
+	return <-retry.result
+
+}
+
+// Hand the event of the dead letter over to the projector or reactor that failed to handle it. Events that couldn't
+// be decoded are handed over to all projectors and reactors. The projectors apply the event even if they handled it
+// before. Only called by the processor go routine.
+func (p *Processor) retryDeadLetter(retry deadLetterRetry) {
+
+	letter := retry.letter
+
+	persistedEvent, err := p.eventRepository.FetchByID(letter.EventID)
+	if err != nil {
+		retry.result <- err
+		return
+	}
+
+	esEvent, err := p.eventRegistry.EventToESEvent(persistedEvent)
+	if err != nil {
+		p.deadLetter(persistedEvent, "", "", err, 1)
+		retry.result <- err
+		return
+	}
+
+	projected := &sync.WaitGroup{}
+	report := newReport(letter.EventID)
+
+	// reacting on the event is the last step of retrying it
+	react := reaction{
+		ctx:            p.ctx,
+		persistedEvent: persistedEvent,
+		projected:      projected,
+		reprocess:      true,
+		onProcessed:    make(chan struct{}, 1),
+		report:         report,
+		reactor:        letter.Reactor,
+	}
+
+	if letter.Reactor == "" {
+		for _, projector := range p.projectorRegistry.ProjectorsForEvent(esEvent) {
+
+			if letter.Projector != "" && letter.Projector != projector.Name() {
+				continue
+			}
+
+			projected.Add(1)
+			p.projectorWorker(projector).jobs <- projectorJob{
+				ctx:            p.ctx,
+				persistedEvent: persistedEvent,
+				esEvent:        esEvent,
+				done:           projected,
+				report:         report,
+				reprocess:      true,
+			}
+
+		}
+	}
+
+	// the reactors only react in case they didn't get the event before
+	if letter.Projector == "" {
+		react.esEvent = esEvent
+	}
+	p.handOver(react)
+
+	go func() {
+		<-react.onProcessed
+		retry.result <- report.err()
+	}()
+
+}
+
+// Hands the events over to the workers of the reactors in the order they got processed. An event is only reacted on
+// once all projectors applied it. Afterwards it's marked as processed (once the reactors are done unless they are
+// detached). The workers of the reactors catch up on the events they missed before they get the new events.
+func (p *Processor) react() {
+
+	// the events the reactors catch up on might be queued as well
+	caughtUp, caughtUpDone := map[string]primitive.ObjectID{}, &sync.WaitGroup{}
+	if !p.replay {
+		caughtUp, caughtUpDone = p.catchUpReactors()
+	}
+	go func() {
+		caughtUpDone.Wait()
+		p.reacting.Done()
+	}()
+
+	// marks the reactions as processed in order once the reactors are done
+	acknowledgements := make(chan reaction, p.config.projectorQueueSize)
+	acknowledged := make(chan struct{})
+	go func() {
+		defer close(acknowledged)
+		for reaction := range acknowledgements {
+			reaction.reacted.Wait()
+			reaction.onProcessed <- struct{}{}
+			p.reacting.Done()
+		}
+	}()
+
+	for reaction := range p.reactions {
+
+		// wait till the projectors are done
+		reaction.projected.Wait()
+
+		reaction.reacted = &sync.WaitGroup{}
+
+		if !p.replay && reaction.esEvent != nil {
+
+			// the report is handed out before detached reactors are done - their results are not part of it
+			report := reaction.report
+			if reaction.detached {
+				report = newReport(*reaction.persistedEvent.ID)
+			}
+
+			reactors := p.reactorRegistry.NamedReactors(reaction.esEvent)
+
+			for _, reactor := range reactors {
+				if reaction.reactor != "" && reaction.reactor != reactor.Name {
+					continue
+				}
+
+				// the reactor caught up on the event already - the checkpoint must not move back
+				reacted := p.reacted(reactor.Name, reaction.persistedEvent)
+				catchingUp := false
+				if lastCaughtUp, k := caughtUp[reactor.Name]; k && reaction.persistedEvent.ID != nil && bytes.Compare(reaction.persistedEvent.ID[:], lastCaughtUp[:]) <= 0 {
+					reacted, catchingUp = true, true
+				}
+				if reacted && !reaction.reprocess {
+					// the event is processed once the reactor caught up on it
+					if catchingUp {
+						reaction.reacted.Add(1)
+						go func(reacted *sync.WaitGroup) {
+							caughtUpDone.Wait()
+							reacted.Done()
+						}(reaction.reacted)
+					}
+					continue
+				}
+
+				reaction.reacted.Add(1)
+				p.reactorWorker(reactor.Name).jobs <- reactorJob{
+					ctx:              reaction.ctx,
+					persistedEvent:   reaction.persistedEvent,
+					esEvent:          reaction.esEvent,
+					reactor:          reactor,
+					done:             reaction.reacted,
+					report:           report,
+					updateCheckpoint: !reacted,
+				}
+			}
+
+		}
+
+		if reaction.detached {
+			reaction.onProcessed <- struct{}{}
+			go func(reacted *sync.WaitGroup) {
+				reacted.Wait()
+				p.reacting.Done()
+			}(reaction.reacted)
+			continue
+		}
+
+		acknowledgements <- reaction
+
+	}
+
+	// wait till the reactors are done
+	for _, worker := range p.reactorWorkers {
+		close(worker.jobs)
+	}
+	close(acknowledgements)
+	<-acknowledged
+
+}
+
+// hand the reaction over to the reactor go routine (only called by the processor go routine)
+func (p *Processor) handOver(reaction reaction) {
+	p.reacting.Add(1)
+	p.reactions <- reaction
+}
+
+// the worker of the reactor with the given name (only called by the reactor go routine)
+func (p *Processor) reactorWorker(reactorName string) *reactorWorker {
+
+	worker, exists := p.reactorWorkers[reactorName]
+	if exists {
+		return worker
+	}
+
+	worker = newReactorWorker(p.reactorRegistry.ExecutionPolicy(reactorName), p.config.projectorQueueSize)
+	p.reactorWorkers[reactorName] = worker
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		worker.run(p)
+	}()
+
+	return worker
+
+}
+
 func newProcessor(
 	projectorRegistry *projector.Registry,
 	eventRegistry *event.Registry,
 	reactorRegistry *reactor.Registry,
 	projectorRepository projector.IProjectorRepository,
+	reactorCheckpointRepository projector.IProjectorRepository,
 	eventRepository event.IEventRepository,
+	lockRepository replayLockRepository,
+	deadLetterRepository deadLetterRepository,
+	outboxRepository outboxRepository,
 	logger ILogger,
-	replay bool) *Processor {
+	replay bool,
+	config *config) *Processor {
 
 	stop := make(chan struct{})
-	eventQueue := make(chan processEvent, 100)
+	eventQueue := make(chan processEvent, config.eventQueueSize)
 	start := make(chan struct{}, 1)
+	pauseRequests := make(chan chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 
 	p := &Processor{
-		stop:                stop,
-		projectorRegistry:   projectorRegistry,
-		projectorRepository: projectorRepository,
-		eventQueue:          eventQueue,
-		start:               start,
+		stop:                        stop,
+		projectorRegistry:           projectorRegistry,
+		eventRegistry:               eventRegistry,
+		reactorRegistry:             reactorRegistry,
+		projectorRepository:         projectorRepository,
+		reactorCheckpointRepository: reactorCheckpointRepository,
+		reactorCheckpoints:          map[string]*primitive.ObjectID{},
+		reactorCheckpointsLock:      &sync.Mutex{},
+		reactorWorkers:              map[string]*reactorWorker{},
+		reactorSlots:                make(chan struct{}, config.reactorWorkers),
+		eventRepository:             eventRepository,
+		lockRepository:              lockRepository,
+		deadLetterRepository:        deadLetterRepository,
+		outboxRepository:            outboxRepository,
+		logger:                      logger,
+		replay:                      replay,
+		config:                      config,
+		eventQueue:                  eventQueue,
+		start:                       start,
+		pauseRequests:               pauseRequests,
+		workers:                     map[string]*projectorWorker{},
+		reactions:                   make(chan reaction, config.projectorQueueSize),
+		skippedLock:                 &sync.Mutex{},
+		skipped:                     map[primitive.ObjectID]processEvent{},
+		catchUp:                     make(chan struct{}, 1),
+		shutdown:                    make(chan struct{}),
+		stopped:                     make(chan struct{}),
+		running:                     &sync.WaitGroup{},
+		reacting:                    &sync.WaitGroup{},
+		retries:                     make(chan deadLetterRetry),
+		ctx:                         ctx,
+		cancel:                      cancel,
 	}
 
 	go func() {
 
-		// wait for start signal
-		<-start
+		// wait for start signal - there is nothing to drain if we never started
+		select {
+		case <-start:
+		case <-p.shutdown:
+			// the processor might have been started right before the shutdown
+			select {
+			case <-start:
+			default:
+				p.cancel()
+				close(p.stopped)
+				return
+			}
+		}
 		close(start)
 
+		// react on the events once they got projected - the reactors catch up first
+		p.reacting.Add(1)
+		p.running.Add(1)
+		go func() {
+			defer p.running.Done()
+			p.react()
+		}()
+
+		// while a replay is running the events are buffered instead of processed
+		paused := p.replayLocked()
+		buffered := []processEvent{}
+		catchUpPending := false
+
+		lockTicker := time.NewTicker(replayLockPollInterval)
+		defer lockTicker.Stop()
+
+		shutdown := p.shutdown
+		shuttingDown := false
+
 		for {
 
+			// shut down once all events are handed over to the workers and the reactors
+			if shuttingDown && !paused && len(eventQueue) == 0 && atomic.LoadInt32(&p.behind) == 0 {
+
+				// the reactors might commit follow up events till they are done - those must be processed as well
+				p.reacting.Wait()
+				if len(eventQueue) > 0 || atomic.LoadInt32(&p.behind) == 1 {
+					continue
+				}
+
+				for _, worker := range p.workers {
+					close(worker.jobs)
+				}
+				close(p.reactions)
+				p.running.Wait()
+				p.cancel()
+				close(p.stopped)
+				return
+			}
+
+			// dead letters are retried once the replay is done
+			retries := p.retries
+			if paused {
+				retries = nil
+			}
+
 			select {
 
 			// handle occurred event
 			case processEvent := <-eventQueue:
 
-				eventID := processEvent.eventID
-				onProcessed := processEvent.onProcessed
-
-				// @todo check if event already got handled
-
-				// persisted event
-				persistedEvent, err := eventRepository.FetchByID(eventID)
-				if err != nil {
-					logger.Error(err)
-					onProcessed <- struct{}{}
+				if paused {
+					buffered = append(buffered, processEvent)
 					continue
 				}
 
-				// transform persisted event to event sourcing event
-				esEvent, err := eventRegistry.EventToESEvent(persistedEvent)
-				if err != nil {
-					logger.Error(err)
-					onProcessed <- struct{}{}
+				p.process(processEvent, nil)
+
+			// process the events that couldn't be queued
+			case <-p.catchUp:
+
+				if paused {
+					catchUpPending = true
 					continue
 				}
 
-				// project event
-				projectors := projectorRegistry.ProjectorsForEvent(esEvent)
-				for _, projector := range projectors {
+				p.catchUpSkipped(nil)
 
-					if !replay {
+			// retry a dead letter
+			case retry := <-retries:
+				p.retryDeadLetter(retry)
 
-						// make sure that the projector is not out of sync
-						outOfSyncBy, err := projectorRepository.OutOfSyncBy(projector)
-						if err != nil {
-							logger.Error(err)
-							continue
-						}
+			// pause till the replay is done
+			case acknowledge := <-pauseRequests:
+				paused = true
 
-						// report if projector is out of sync. Being out of sync by one is fine since we are about to process the event
-						if outOfSyncBy > 1 {
-							logger.Error(fmt.Errorf("projector '%s' is out of sync - tried to apply event with name '%s'", projector.Name(), persistedEvent.Name))
-							continue
-						}
+				// the replay must not touch the projectors and reactors before they finished their events - the
+				// reactors might commit follow up events till then, so we must not block
+				go func() {
+					p.reacting.Wait()
+					acknowledge <- struct{}{}
+				}()
 
-					}
+			// pause in case a replay got started and check if it's done
+			case <-lockTicker.C:
 
-					// handle event
-					if err := projector.Handle(esEvent); err != nil {
-						logger.Error(err)
+				var lock *replayLock
+				if p.lockRepository != nil {
+					fetchedLock, err := p.lockRepository.Fetch()
+					if err != nil {
+						p.logger.Error(err)
 						continue
 					}
+					lock = fetchedLock
+				}
 
-					// updated the last handled event on the projector
-					err = projectorRepository.UpdateLastHandledEvent(projector, persistedEvent)
-					if err != nil {
-						logger.Error(err)
-					}
+				held := lock.held(time.Now())
 
+				// a replay got started
+				if !paused {
+					paused = held
+					continue
 				}
 
-				if !replay {
+				if held {
+					continue
+				}
 
-					reactors := reactorRegistry.Reactors(esEvent)
+				paused = false
 
-					for _, reactor := range reactors {
-						reactor(esEvent)
-					}
+				// hand over - the buffered events might have been applied to the projectors by the replay
+				var handover *replayHandover
+				if lock != nil {
+					handover = lock.Handover
+				}
+				for _, bufferedEvent := range buffered {
+					p.process(bufferedEvent, handover)
+				}
+				buffered = []processEvent{}
 
+				if catchUpPending {
+					catchUpPending = false
+					p.catchUpSkipped(handover)
 				}
 
-				onProcessed <- struct{}{}
+			// drain the queue and shut down
+			case <-shutdown:
+				shutdown = nil
+				shuttingDown = true
 
-			// kill go routine
+			// kill go routine as well as the workers
 			case <-stop:
+				for _, worker := range p.workers {
+					close(worker.jobs)
+				}
+				close(p.reactions)
+				close(p.stopped)
 				return
 			}
 
@@ -3,12 +3,16 @@ package es
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/florianlenz/event-sourcing-go/event"
 	"github.com/florianlenz/event-sourcing-go/projector"
 	"github.com/florianlenz/event-sourcing-go/reactor"
 	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
 	. "github.com/smartystreets/goconvey/convey"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -18,6 +22,8 @@ type testProjectorRepository struct {
 	outOfSyncBy            func(projector projector.IProjector) (int64, error)
 	updateLastHandledEvent func(projector projector.IProjector, event event.Event) error
 	drop                   func() error
+	reset                  func(projector projector.IProjector) error
+	lastHandledEvent       func(projector projector.IProjector) (*primitive.ObjectID, error)
 }
 
 func (r *testProjectorRepository) OutOfSyncBy(projector projector.IProjector) (int64, error) {
@@ -28,10 +34,275 @@ func (r *testProjectorRepository) UpdateLastHandledEvent(projector projector.IPr
 	return r.updateLastHandledEvent(projector, event)
 }
 
+func (r *testProjectorRepository) LastHandledEvent(projector projector.IProjector) (*primitive.ObjectID, error) {
+	// projectors didn't handle any event by default
+	if r.lastHandledEvent == nil {
+		return nil, nil
+	}
+	return r.lastHandledEvent(projector)
+}
+
 func (r *testProjectorRepository) Drop() error {
 	return r.drop()
 }
 
+func (r *testProjectorRepository) Reset(projector projector.IProjector) error {
+	return r.reset(projector)
+}
+
+// test replay lock repository
+type testReplayLockRepository struct {
+	fetch func() (*replayLock, error)
+}
+
+func (r *testReplayLockRepository) Fetch() (*replayLock, error) {
+	return r.fetch()
+}
+
+func (r *testReplayLockRepository) Acquire(owner string, expiresAt time.Time) error {
+	panic("processor is not supposed to acquire the replay lock")
+}
+
+func (r *testReplayLockRepository) Refresh(owner string, expiresAt time.Time) error {
+	panic("processor is not supposed to refresh the replay lock")
+}
+
+func (r *testReplayLockRepository) Release(owner string, handover *replayHandover) error {
+	panic("processor is not supposed to release the replay lock")
+}
+
+// test dead letter repository that keeps the dead letters in memory
+type testDeadLetterRepository struct {
+	lock    *sync.Mutex
+	letters []DeadLetter
+}
+
+func (r *testDeadLetterRepository) Save(letter DeadLetter) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, l := range r.letters {
+		if l.EventID == letter.EventID && l.Projector == letter.Projector && l.Reactor == letter.Reactor {
+			r.letters[i].Error = letter.Error
+			r.letters[i].Stack = letter.Stack
+			r.letters[i].Attempts += letter.Attempts
+			return nil
+		}
+	}
+
+	id := primitive.NewObjectID()
+	letter.ID = &id
+	r.letters = append(r.letters, letter)
+
+	return nil
+
+}
+
+func (r *testDeadLetterRepository) All() ([]DeadLetter, error) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]DeadLetter{}, r.letters...), nil
+
+}
+
+func (r *testDeadLetterRepository) FetchByID(id primitive.ObjectID) (DeadLetter, error) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, letter := range r.letters {
+		if *letter.ID == id {
+			return letter, nil
+		}
+	}
+
+	return DeadLetter{}, ErrDeadLetterNotFound
+
+}
+
+func (r *testDeadLetterRepository) Delete(id primitive.ObjectID) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, letter := range r.letters {
+		if *letter.ID == id {
+			r.letters = append(r.letters[:i], r.letters[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+
+}
+
+// test projector that handles the events in multiple partitions
+type testPartitionedProjector struct {
+	*testProjector
+	partitions   int
+	partitionKey func(event event.IESEvent) string
+}
+
+func (p *testPartitionedProjector) Partitions() int {
+	return p.partitions
+}
+
+func (p *testPartitionedProjector) PartitionKey(event event.IESEvent) string {
+	return p.partitionKey(event)
+}
+
+// test projector that handles the events in batches
+type testBatchProjector struct {
+	*testProjector
+	handleBatch func(events []event.IESEvent) error
+}
+
+func (p *testBatchProjector) HandleBatch(events []event.IESEvent) error {
+	return p.handleBatch(events)
+}
+
+// test projector that writes its read model within a transaction
+type testTransactionalProjector struct {
+	*testProjector
+	handleInTransaction func(tx projector.ITransaction, event event.IESEvent) error
+}
+
+func (p *testTransactionalProjector) HandleInTransaction(tx projector.ITransaction, event event.IESEvent) error {
+	return p.handleInTransaction(tx, event)
+}
+
+// test projector that writes its read model within a transaction and handles the events in multiple partitions
+type testPartitionedTransactionalProjector struct {
+	*testPartitionedProjector
+	handleInTransaction func(tx projector.ITransaction, event event.IESEvent) error
+}
+
+func (p *testPartitionedTransactionalProjector) HandleInTransaction(tx projector.ITransaction, event event.IESEvent) error {
+	return p.handleInTransaction(tx, event)
+}
+
+// test transaction
+type testTransaction struct {
+	ctx context.Context
+	// operations done within the transaction
+	operations []string
+}
+
+func (t *testTransaction) Context() context.Context {
+	return t.ctx
+}
+
+// test projector repository that supports transactions
+type testTransactionalProjectorRepository struct {
+	*testProjectorRepository
+	// transactions that got committed
+	committed chan *testTransaction
+}
+
+func (r *testTransactionalProjectorRepository) InTransaction(ctx context.Context, fn func(tx projector.ITransaction) error) error {
+
+	tx := &testTransaction{ctx: ctx}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	r.committed <- tx
+
+	return nil
+
+}
+
+func (r *testTransactionalProjectorRepository) UpdateLastHandledEventInTransaction(tx projector.ITransaction, projector projector.IProjector, event event.Event) error {
+	tx.(*testTransaction).operations = append(tx.(*testTransaction).operations, "checkpoint "+event.ID.Hex())
+	return nil
+}
+
+// test projector that wants a context
+type testContextProjector struct {
+	*testProjector
+	handleContext func(ctx context.Context, event event.IESEvent) error
+}
+
+func (p *testContextProjector) HandleContext(ctx context.Context, event event.IESEvent) error {
+	return p.handleContext(ctx, event)
+}
+
+// test reactor that wants a context
+type testContextReactor struct {
+	handle func(ctx context.Context, event event.IESEvent)
+}
+
+func (r *testContextReactor) HandleContext(ctx context.Context, event testEvent) {
+	r.handle(ctx, event)
+}
+
+// test event that belongs to a stream
+type testStreamEventPayload struct {
+	Stream string `es:"stream"`
+}
+
+type testStreamEvent struct {
+	event.ESEvent
+	Payload testStreamEventPayload
+}
+
+func (e testStreamEvent) StreamID() string {
+	return e.Payload.Stream
+}
+
+// test reactor that reacts on stream events
+type testStreamReactor struct {
+	handle func(event testStreamEvent)
+}
+
+func (r *testStreamReactor) Handle(event testStreamEvent) {
+	r.handle(event)
+}
+
+// test reactor that commits follow up events
+type testFollowUpReactor struct {
+	react func(event testEvent) ([]event.IESEvent, error)
+}
+
+func (r *testFollowUpReactor) Handle(event testEvent) ([]event.IESEvent, error) {
+	return r.react(event)
+}
+
+// test reactor that reports errors
+type testFailingReactor struct {
+	handle func(ctx context.Context, event event.IESEvent) error
+}
+
+func (r *testFailingReactor) HandleContext(ctx context.Context, event testEvent) error {
+	return r.handle(ctx, event)
+}
+
+// test reactor with a name
+type testNamedReactor struct {
+	name   string
+	handle func(event event.IESEvent)
+}
+
+func (r *testNamedReactor) Name() string {
+	return r.name
+}
+
+func (r *testNamedReactor) Handle(event testEvent) {
+	r.handle(event)
+}
+
+// test reactor that sends its messages via the outbox
+type testOutboxReactor struct {
+	outbox func(event event.IESEvent) []reactor.Message
+}
+
+func (r *testOutboxReactor) Outbox(event testEvent) []reactor.Message {
+	return r.outbox(event)
+}
+
 // test reactor
 type testReactor struct {
 	handle func(event event.IESEvent)
@@ -44,14 +315,17 @@ func (r *testReactor) Handle(event testEvent) {
 func TestProcessor(t *testing.T) {
 
 	type processorTestSet struct {
-		processor         *Processor
-		logger            *testLogger
-		projectorRegistry *projector.Registry
-		eventRegistry     *event.Registry
-		reactorRegistry   *reactor.Registry
+		processor          *Processor
+		logger             *testLogger
+		projectorRegistry  *projector.Registry
+		eventRegistry      *event.Registry
+		reactorRegistry    *reactor.Registry
+		deadLetters        *testDeadLetterRepository
+		reactorCheckpoints projector.IProjectorRepository
+		outbox             *testOutboxRepository
 	}
 
-	var newProcessorTestSet = func(replay bool, eventRepository event.IEventRepository, projectorRepository projector.IProjectorRepository) (*processorTestSet, error) {
+	var newProcessorTestSet = func(replay bool, eventRepository event.IEventRepository, projectorRepository projector.IProjectorRepository, options ...Option) (*processorTestSet, error) {
 
 		// logger
 		logger := &testLogger{
@@ -87,14 +361,30 @@ func TestProcessor(t *testing.T) {
 			eventRepository = event.NewEventRepository(db.Collection("events"))
 		}
 
-		processor := newProcessor(projectorRegistry, eventRegistry, reactorRegistry, projectorRepository, eventRepository, logger, replay)
+		// dead letters
+		deadLetterRepository := &testDeadLetterRepository{
+			lock: &sync.Mutex{},
+		}
+
+		// outbox
+		outboxRepository := &testOutboxRepository{
+			lock: &sync.Mutex{},
+		}
+
+		// reactor checkpoints
+		reactorCheckpointRepository := projector.NewMemoryProjectorRepository(eventRepository, eventRegistry)
+
+		processor := newProcessor(projectorRegistry, eventRegistry, reactorRegistry, projectorRepository, reactorCheckpointRepository, eventRepository, nil, deadLetterRepository, outboxRepository, logger, replay, newConfig(options...))
 
 		p := &processorTestSet{
-			processor:         processor,
-			logger:            logger,
-			projectorRegistry: projectorRegistry,
-			eventRegistry:     eventRegistry,
-			reactorRegistry:   reactorRegistry,
+			processor:          processor,
+			logger:             logger,
+			projectorRegistry:  projectorRegistry,
+			eventRegistry:      eventRegistry,
+			reactorRegistry:    reactorRegistry,
+			deadLetters:        deadLetterRepository,
+			reactorCheckpoints: reactorCheckpointRepository,
+			outbox:             outboxRepository,
 		}
 
 		return p, nil
@@ -488,6 +778,2096 @@ func TestProcessor(t *testing.T) {
 
 		})
 
+		Convey("a slow projector must not block the other projectors", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projectors
+			releaseSlowProjector := make(chan struct{})
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "slow.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					<-releaseSlowProjector
+					return nil
+				},
+			}), ShouldBeNil)
+			handledByFastProjector := make(chan struct{}, 2)
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "fast.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					handledByFastProjector <- struct{}{}
+					return nil
+				},
+			}), ShouldBeNil)
+
+			onFirstProcessed := processor.Process(primitive.NewObjectID())
+			onSecondProcessed := processor.Process(primitive.NewObjectID())
+
+			// the fast projector handles both events while the slow projector is still busy
+			So(<-handledByFastProjector, ShouldResemble, struct{}{})
+			So(<-handledByFastProjector, ShouldResemble, struct{}{})
+
+			// the events are only processed once all projectors applied them
+			select {
+			case <-onFirstProcessed:
+				panic("didn't expect event to be processed before the slow projector applied it")
+			case <-time.After(time.Second):
+			}
+
+			close(releaseSlowProjector)
+			So(<-onFirstProcessed, ShouldResemble, struct{}{})
+			So(<-onSecondProcessed, ShouldResemble, struct{}{})
+
+		})
+
+		Convey("a partitioned projector must handle events of different partitions concurrently and keep the order within a partition", func() {
+
+			// the version of the event is used as partition key. Version 1 and 3 end up in the same partition.
+			So(partition("1", 2), ShouldNotEqual, partition("2", 2))
+			So(partition("1", 2), ShouldEqual, partition("3", 2))
+
+			firstEventID := primitive.NewObjectID()
+			secondEventID := primitive.NewObjectID()
+			thirdEventID := primitive.NewObjectID()
+			versions := map[primitive.ObjectID]uint8{
+				firstEventID:  1,
+				secondEventID: 2,
+				thirdEventID:  3,
+			}
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:      &id,
+						Name:    "user.registered",
+						Version: versions[id],
+					}, nil
+				},
+			}
+
+			// projector repository
+			checkpoints := make(chan uint8, 3)
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					checkpoints <- event.Version
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector - the first event blocks its partition till it got released
+			releaseFirstEvent := make(chan struct{})
+			handled := make(chan uint8, 3)
+			So(processorTestSet.projectorRegistry.Register(&testPartitionedProjector{
+				testProjector: &testProjector{
+					name: "user.projector",
+					interestedInEvents: []event.IESEvent{
+						&testEvent{},
+					},
+					handleEvent: func(event event.IESEvent) error {
+						if event.Version() == 1 {
+							<-releaseFirstEvent
+						}
+						handled <- event.Version()
+						return nil
+					},
+				},
+				partitions: 2,
+				partitionKey: func(event event.IESEvent) string {
+					return strconv.Itoa(int(event.Version()))
+				},
+			}), ShouldBeNil)
+
+			onFirstProcessed := processor.Process(firstEventID)
+			onSecondProcessed := processor.Process(secondEventID)
+			onThirdProcessed := processor.Process(thirdEventID)
+
+			// the second event is handled while the first one is still busy
+			So(<-handled, ShouldEqual, 2)
+
+			// the third event must wait for the first one since they are in the same partition
+			select {
+			case version := <-handled:
+				panic("didn't expect event with version " + strconv.Itoa(int(version)) + " to be handled before the first event")
+			case <-time.After(time.Second):
+			}
+
+			// the checkpoint must not skip the first event
+			So(checkpoints, ShouldHaveLength, 0)
+
+			close(releaseFirstEvent)
+			So(<-handled, ShouldEqual, 1)
+			So(<-handled, ShouldEqual, 3)
+
+			So(<-onFirstProcessed, ShouldResemble, struct{}{})
+			So(<-onSecondProcessed, ShouldResemble, struct{}{})
+			So(<-onThirdProcessed, ShouldResemble, struct{}{})
+
+			// the checkpoint is moved in the order the events got processed
+			So(<-checkpoints, ShouldEqual, 1)
+			So(<-checkpoints, ShouldEqual, 2)
+			So(<-checkpoints, ShouldEqual, 3)
+
+		})
+
+		Convey("a batch projector must get the queued events at once and update the checkpoint once per batch", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			checkpoints := make(chan primitive.ObjectID, 3)
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					checkpoints <- *event.ID
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector - the first batch blocks till it got released
+			releaseFirstBatch := make(chan struct{})
+			batches := make(chan int, 3)
+			So(processorTestSet.projectorRegistry.Register(&testBatchProjector{
+				testProjector: &testProjector{
+					name: "user.projector",
+					interestedInEvents: []event.IESEvent{
+						&testEvent{},
+					},
+					handleEvent: func(event event.IESEvent) error {
+						panic("expected the events to be handled as batch")
+					},
+				},
+				handleBatch: func(events []event.IESEvent) error {
+					batches <- len(events)
+					if len(batches) == 1 {
+						<-releaseFirstBatch
+					}
+					return nil
+				},
+			}), ShouldBeNil)
+
+			onFirstProcessed := processor.Process(primitive.NewObjectID())
+			So(<-batches, ShouldEqual, 1)
+
+			// the events queue up while the first batch is handled
+			secondEventID := primitive.NewObjectID()
+			thirdEventID := primitive.NewObjectID()
+			onSecondProcessed := processor.Process(secondEventID)
+			onThirdProcessed := processor.Process(thirdEventID)
+			time.Sleep(time.Second)
+
+			close(releaseFirstBatch)
+			So(<-onFirstProcessed, ShouldResemble, struct{}{})
+			So(<-onSecondProcessed, ShouldResemble, struct{}{})
+			So(<-onThirdProcessed, ShouldResemble, struct{}{})
+
+			// the queued events are handled together
+			So(<-batches, ShouldEqual, 2)
+
+			// the checkpoint got updated once per batch
+			So(checkpoints, ShouldHaveLength, 2)
+			<-checkpoints
+			So(<-checkpoints, ShouldEqual, thirdEventID)
+
+		})
+
+		Convey("events that couldn't be queued in time must be caught up from the event store in order", func() {
+
+			eventIDs := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+				mapEvents: func(query event.Query, cb func(event event.Event)) error {
+					// the catch up starts with the first event that couldn't be queued
+					if *query.From != eventIDs[1] {
+						return errors.New("expected catch up to start with the second event")
+					}
+					for _, eventID := range eventIDs[1:] {
+						id := eventID
+						cb(event.Event{
+							ID:   &id,
+							Name: "user.registered",
+						})
+					}
+					return nil
+				},
+			}
+
+			// projector repository
+			checkpoints := make(chan primitive.ObjectID, 3)
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					checkpoints <- *event.ID
+					return nil
+				},
+			}
+
+			// create new processor with a queue that only holds one event
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository, WithEventQueueSize(1))
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					return nil
+				},
+			}), ShouldBeNil)
+
+			// the first event fills the queue since the processor hasn't been started yet
+			onFirstProcessed, err := processor.ProcessContext(context.Background(), eventIDs[0])
+			So(err, ShouldBeNil)
+
+			// the second event can't be queued before the deadline
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+			defer cancel()
+			_, err = processor.ProcessContext(ctx, eventIDs[1])
+			So(err, ShouldResemble, context.DeadlineExceeded)
+
+			// the third event must not overtake the second one
+			onThirdProcessed, err := processor.ProcessContext(context.Background(), eventIDs[2])
+			So(err, ShouldBeNil)
+
+			processor.Start()
+			So(<-onFirstProcessed, ShouldResemble, struct{}{})
+			So(<-onThirdProcessed, ShouldResemble, struct{}{})
+
+			So(<-checkpoints, ShouldEqual, eventIDs[0])
+			So(<-checkpoints, ShouldEqual, eventIDs[1])
+			So(<-checkpoints, ShouldEqual, eventIDs[2])
+
+		})
+
+		Convey("persisted events must be caught up instead of failing fast in case the queue is full", func() {
+
+			// create new processor with a queue that only holds one event
+			processorTestSet, err := newProcessorTestSet(false, &testEventRepository{}, &testProjectorRepository{}, WithEventQueueSize(1), WithQueueFullPolicy(QueueFullFailFast))
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+
+			_, err = processor.ProcessContext(context.Background(), primitive.NewObjectID())
+			So(err, ShouldBeNil)
+			So(processor.queueFull(), ShouldBeTrue)
+
+			// the event got persisted before the queue filled up - the caller must not commit it again
+			eventID := primitive.NewObjectID()
+			_, err = processor.ProcessContext(context.Background(), eventID)
+			So(err, ShouldBeNil)
+			So(processor.skipped, ShouldContainKey, eventID)
+
+		})
+
+		Convey("report the result of each projector and reactor", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projectors
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					return nil
+				},
+			}), ShouldBeNil)
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "failing.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					return errors.New("failed to apply event")
+				},
+			}), ShouldBeNil)
+
+			// register reactor
+			So(processorTestSet.reactorRegistry.Register(&testReactor{
+				handle: func(event event.IESEvent) {},
+			}), ShouldBeNil)
+
+			// process event
+			processEvent, err := processor.enqueue(context.Background(), primitive.NewObjectID(), QueueFullBlock)
+			So(err, ShouldBeNil)
+			report, err := newCommitHandle(processEvent).Wait(context.Background())
+			So(err, ShouldBeNil)
+
+			So(report.Failed(), ShouldBeTrue)
+			So(report.Error, ShouldBeNil)
+			So(report.Projectors, ShouldHaveLength, 2)
+			So(report.Projectors["user.projector"], ShouldBeNil)
+			So(report.Projectors["failing.projector"], ShouldResemble, errors.New("failed to apply event"))
+			So(report.Reactors, ShouldResemble, map[string]error{"es.testReactor": nil})
+
+		})
+
+		Convey("shutdown must drain the queued events", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			checkpoints := make(chan primitive.ObjectID, 2)
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					checkpoints <- *event.ID
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector - it blocks till it got released
+			releaseProjector := make(chan struct{})
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					<-releaseProjector
+					return nil
+				},
+			}), ShouldBeNil)
+
+			// queue events
+			_, err = processor.ProcessContext(context.Background(), primitive.NewObjectID())
+			So(err, ShouldBeNil)
+			_, err = processor.ProcessContext(context.Background(), primitive.NewObjectID())
+			So(err, ShouldBeNil)
+			processor.Start()
+
+			// the shutdown waits for the projector
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+			defer cancel()
+			So(processor.Shutdown(ctx), ShouldResemble, context.DeadlineExceeded)
+
+			// events are rejected while shutting down
+			_, err = processor.ProcessContext(context.Background(), primitive.NewObjectID())
+			So(err, ShouldEqual, ErrShutdown)
+
+			close(releaseProjector)
+			So(processor.Shutdown(context.Background()), ShouldBeNil)
+
+			// the queued events got applied
+			So(checkpoints, ShouldHaveLength, 2)
+
+		})
+
+		Convey("shutdown of a processor that never started must return right away", func() {
+
+			processorTestSet, err := newProcessorTestSet(false, &testEventRepository{}, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+
+			So(processorTestSet.processor.Shutdown(context.Background()), ShouldBeNil)
+
+		})
+
+		Convey("events the projector already handled must be skipped unless reprocessing is forced", func() {
+
+			olderEventID := primitive.NewObjectID()
+			lastHandledEventID := primitive.NewObjectID()
+			newerEventID := primitive.NewObjectID()
+
+			for _, forced := range []bool{false, true} {
+
+				// mock event repository
+				eventRepo := &testEventRepository{
+					fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+						return event.Event{
+							ID:   &id,
+							Name: "user.registered",
+						}, nil
+					},
+				}
+
+				// projector repository
+				checkpoints := make(chan primitive.ObjectID, 2)
+				projectorRepository := &testProjectorRepository{
+					outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+						return 1, nil
+					},
+					updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+						checkpoints <- *event.ID
+						return nil
+					},
+					lastHandledEvent: func(projector projector.IProjector) (*primitive.ObjectID, error) {
+						return &lastHandledEventID, nil
+					},
+				}
+
+				// create new processor
+				options := []Option{}
+				if forced {
+					options = append(options, WithForcedReprocessing())
+				}
+				processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository, options...)
+				So(err, ShouldBeNil)
+				processor := processorTestSet.processor
+				processor.Start()
+
+				// register event
+				So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+				// register projector
+				handled := 0
+				So(processorTestSet.projectorRegistry.Register(&testProjector{
+					name: "user.projector",
+					interestedInEvents: []event.IESEvent{
+						&testEvent{},
+					},
+					handleEvent: func(event event.IESEvent) error {
+						handled++
+						return nil
+					},
+				}), ShouldBeNil)
+
+				So(<-processor.Process(olderEventID), ShouldResemble, struct{}{})
+				So(<-processor.Process(lastHandledEventID), ShouldResemble, struct{}{})
+				So(<-processor.Process(newerEventID), ShouldResemble, struct{}{})
+
+				// the checkpoint only moves forward
+				So(checkpoints, ShouldHaveLength, 1)
+				So(<-checkpoints, ShouldEqual, newerEventID)
+
+				if forced {
+					So(handled, ShouldEqual, 3)
+				} else {
+					So(handled, ShouldEqual, 1)
+				}
+
+			}
+
+		})
+
+		Convey("transactional projectors must write the read model and the checkpoint in one transaction", func() {
+
+			eventID := primitive.NewObjectID()
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testTransactionalProjectorRepository{
+				testProjectorRepository: &testProjectorRepository{
+					outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+						return 1, nil
+					},
+					updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+						panic("the checkpoint must be updated within the transaction")
+					},
+				},
+				committed: make(chan *testTransaction, 1),
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector
+			So(processorTestSet.projectorRegistry.Register(&testTransactionalProjector{
+				testProjector: &testProjector{
+					name: "user.projector",
+					interestedInEvents: []event.IESEvent{
+						&testEvent{},
+					},
+					handleEvent: func(event event.IESEvent) error {
+						panic("the event must be handled within the transaction")
+					},
+				},
+				handleInTransaction: func(tx projector.ITransaction, event event.IESEvent) error {
+					tx.(*testTransaction).operations = append(tx.(*testTransaction).operations, "read model")
+					return nil
+				},
+			}), ShouldBeNil)
+
+			So(<-processor.Process(eventID), ShouldResemble, struct{}{})
+
+			tx := <-projectorRepository.committed
+			So(tx.operations, ShouldResemble, []string{"read model", "checkpoint " + eventID.Hex()})
+
+		})
+
+		Convey("partitioned transactional projectors must move the checkpoint within the transactions in the order the events got processed", func() {
+
+			firstEventID := primitive.NewObjectID()
+			secondEventID := primitive.NewObjectID()
+			thirdEventID := primitive.NewObjectID()
+			versions := map[primitive.ObjectID]uint8{
+				firstEventID:  1,
+				secondEventID: 2,
+				thirdEventID:  3,
+			}
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:      &id,
+						Name:    "user.registered",
+						Version: versions[id],
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testTransactionalProjectorRepository{
+				testProjectorRepository: &testProjectorRepository{
+					outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+						return 1, nil
+					},
+					updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+						panic("the checkpoint must be updated within the transaction")
+					},
+				},
+				committed: make(chan *testTransaction, 3),
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector - the first event blocks its partition till it got released
+			releaseFirstEvent := make(chan struct{})
+			handled := make(chan uint8, 3)
+			So(processorTestSet.projectorRegistry.Register(&testPartitionedTransactionalProjector{
+				testPartitionedProjector: &testPartitionedProjector{
+					testProjector: &testProjector{
+						name: "user.projector",
+						interestedInEvents: []event.IESEvent{
+							&testEvent{},
+						},
+						handleEvent: func(event event.IESEvent) error {
+							panic("the event must be handled within the transaction")
+						},
+					},
+					partitions: 2,
+					partitionKey: func(event event.IESEvent) string {
+						return strconv.Itoa(int(event.Version()))
+					},
+				},
+				handleInTransaction: func(tx projector.ITransaction, event event.IESEvent) error {
+					if event.Version() == 1 {
+						<-releaseFirstEvent
+					}
+					tx.(*testTransaction).operations = append(tx.(*testTransaction).operations, "read model "+strconv.Itoa(int(event.Version())))
+					handled <- event.Version()
+					return nil
+				},
+			}), ShouldBeNil)
+
+			onFirstProcessed := processor.Process(firstEventID)
+			onSecondProcessed := processor.Process(secondEventID)
+			onThirdProcessed := processor.Process(thirdEventID)
+
+			// the second event is handled while the first one is still busy but its transaction must wait
+			So(<-handled, ShouldEqual, 2)
+			select {
+			case <-projectorRepository.committed:
+				panic("didn't expect a transaction to be committed before the first event got handled")
+			case <-time.After(time.Second):
+			}
+
+			close(releaseFirstEvent)
+
+			So(<-onFirstProcessed, ShouldResemble, struct{}{})
+			So(<-onSecondProcessed, ShouldResemble, struct{}{})
+			So(<-onThirdProcessed, ShouldResemble, struct{}{})
+
+			So((<-projectorRepository.committed).operations, ShouldResemble, []string{"read model 1", "checkpoint " + firstEventID.Hex()})
+			So((<-projectorRepository.committed).operations, ShouldResemble, []string{"read model 2", "checkpoint " + secondEventID.Hex()})
+			So((<-projectorRepository.committed).operations, ShouldResemble, []string{"read model 3", "checkpoint " + thirdEventID.Hex()})
+
+		})
+
+		Convey("projector errors must be dealt with according to the error policy of the projector", func() {
+
+			type testCase struct {
+				options []projector.RegisterOption
+				// amount of attempts that fail - the first event fails
+				failures int
+				// events that are handled successfully
+				handled int
+				// events the checkpoint got moved to
+				checkpoints int
+				errors      []string
+			}
+
+			for _, tc := range []testCase{
+				{
+					options:     []projector.RegisterOption{projector.WithRetries(2, time.Millisecond)},
+					failures:    2,
+					handled:     3,
+					checkpoints: 3,
+					errors:      []string{},
+				},
+				{
+					options:     []projector.RegisterOption{projector.WithParkOnFailure()},
+					failures:    1,
+					handled:     2,
+					checkpoints: 3,
+					errors: []string{
+						"projector 'user.projector' parked event with name 'user.registered': failed",
+					},
+				},
+				{
+					options:     []projector.RegisterOption{projector.WithRetries(1, time.Millisecond), projector.WithHaltOnFailure()},
+					failures:    2,
+					handled:     0,
+					checkpoints: 0,
+					errors: []string{
+						"projector 'user.projector' halted on event with name 'user.registered': failed",
+						"projector 'user.projector' is halted",
+						"projector 'user.projector' is halted",
+					},
+				},
+			} {
+
+				// mock event repository
+				eventRepo := &testEventRepository{
+					fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+						return event.Event{
+							ID:   &id,
+							Name: "user.registered",
+						}, nil
+					},
+				}
+
+				// projector repository
+				checkpoints := make(chan primitive.ObjectID, 3)
+				projectorRepository := &testProjectorRepository{
+					outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+						return 1, nil
+					},
+					updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+						checkpoints <- *event.ID
+						return nil
+					},
+				}
+
+				// create new processor
+				processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+				So(err, ShouldBeNil)
+				processor := processorTestSet.processor
+				processor.Start()
+
+				// register event
+				So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+				// register projector
+				failures := tc.failures
+				handled := 0
+				So(processorTestSet.projectorRegistry.Register(&testProjector{
+					name: "user.projector",
+					interestedInEvents: []event.IESEvent{
+						&testEvent{},
+					},
+					handleEvent: func(e event.IESEvent) error {
+						if failures > 0 {
+							failures--
+							return errors.New("failed")
+						}
+						handled++
+						return nil
+					},
+				}, tc.options...), ShouldBeNil)
+
+				So(<-processor.Process(primitive.NewObjectID()), ShouldResemble, struct{}{})
+				So(<-processor.Process(primitive.NewObjectID()), ShouldResemble, struct{}{})
+				So(<-processor.Process(primitive.NewObjectID()), ShouldResemble, struct{}{})
+
+				So(handled, ShouldEqual, tc.handled)
+				So(checkpoints, ShouldHaveLength, tc.checkpoints)
+
+				loggedErrors := []string{}
+				for len(processorTestSet.logger.errorChan) > 0 {
+					loggedErrors = append(loggedErrors, (<-processorTestSet.logger.errorChan).Error())
+				}
+				So(loggedErrors, ShouldResemble, tc.errors)
+
+			}
+
+		})
+
+		Convey("events that couldn't be handled must be recorded as dead letters and can be retried", func() {
+
+			undecodableEventID := primitive.NewObjectID()
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					name := "user.registered"
+					if id == undecodableEventID {
+						name = "user.unknown"
+					}
+					return event.Event{
+						ID:   &id,
+						Name: name,
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector
+			var handleErr error = errors.New("projector failed")
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					return handleErr
+				},
+			}), ShouldBeNil)
+
+			eventID := primitive.NewObjectID()
+			So(<-processor.Process(eventID), ShouldResemble, struct{}{})
+			So(<-processor.Process(undecodableEventID), ShouldResemble, struct{}{})
+
+			letters, err := processorTestSet.deadLetters.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 2)
+
+			So(letters[0].EventID, ShouldEqual, eventID)
+			So(letters[0].EventName, ShouldEqual, "user.registered")
+			So(letters[0].Projector, ShouldEqual, "user.projector")
+			So(letters[0].Error, ShouldEqual, "projector failed")
+			So(letters[0].Stack, ShouldNotBeEmpty)
+			So(letters[0].Attempts, ShouldEqual, 1)
+
+			// the event couldn't be decoded - it's not bound to a projector
+			So(letters[1].EventID, ShouldEqual, undecodableEventID)
+			So(letters[1].Projector, ShouldBeEmpty)
+			So(letters[1].Error, ShouldEqual, "event 'user.unknown' hasn't been registered")
+
+			// a failed retry updates the dead letter
+			So(processor.retry(letters[0]), ShouldBeError, "projector failed")
+			letters, err = processorTestSet.deadLetters.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 2)
+			So(letters[0].Attempts, ShouldEqual, 2)
+
+			handleErr = nil
+			So(processor.retry(letters[0]), ShouldBeNil)
+
+			So(processor.retry(letters[1]), ShouldBeError, "event 'user.unknown' hasn't been registered")
+
+		})
+
+		Convey("panics of projectors and reactors must be recovered and turned into errors", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			checkpoints := make(chan primitive.ObjectID, 2)
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					checkpoints <- *event.ID
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector that panics on the first event
+			handled := 0
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					handled++
+					if handled == 1 {
+						panic("projector panicked")
+					}
+					return nil
+				},
+			}, projector.WithParkOnFailure()), ShouldBeNil)
+
+			// register reactor that panics on the first event
+			reacted := 0
+			So(processorTestSet.reactorRegistry.Register(&testReactor{
+				handle: func(event event.IESEvent) {
+					reacted++
+					if reacted == 1 {
+						panic("reactor panicked")
+					}
+				},
+			}), ShouldBeNil)
+
+			// process event
+			firstEventID := primitive.NewObjectID()
+			processEvent, err := processor.enqueue(context.Background(), firstEventID, QueueFullBlock)
+			So(err, ShouldBeNil)
+			report, err := newCommitHandle(processEvent).Wait(context.Background())
+			So(err, ShouldBeNil)
+
+			So(report.Projectors["user.projector"], ShouldBeError, "projector 'user.projector' parked event with name 'user.registered': handler panicked: projector panicked")
+			So(report.Reactors["es.testReactor"], ShouldBeError, "reactor 'es.testReactor' failed to react on event with name 'user.registered': handler panicked: reactor panicked")
+
+			// the processor keeps going
+			secondEventID := primitive.NewObjectID()
+			So(<-processor.Process(secondEventID), ShouldResemble, struct{}{})
+			So(handled, ShouldEqual, 2)
+			So(reacted, ShouldEqual, 2)
+			So(<-checkpoints, ShouldEqual, firstEventID)
+			So(<-checkpoints, ShouldEqual, secondEventID)
+
+			// the stack of the panic is recorded
+			letters, err := processorTestSet.deadLetters.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 2)
+			So(letters[0].Projector, ShouldEqual, "user.projector")
+			So(letters[0].Error, ShouldEqual, "handler panicked: projector panicked")
+			So(letters[0].Stack, ShouldContainSubstring, "panic")
+			So(letters[1].Reactor, ShouldEqual, "es.testReactor")
+			So(letters[1].Error, ShouldEqual, "handler panicked: reactor panicked")
+
+		})
+
+		Convey("failing reactors must be retried, time out and end up as dead letter", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// the first attempt fails, the second one hangs till it times out and the third one succeeds - all later
+			// attempts fail
+			attempts := int32(0)
+			So(processorTestSet.reactorRegistry.Register(&testFailingReactor{
+				handle: func(ctx context.Context, event event.IESEvent) error {
+					switch atomic.AddInt32(&attempts, 1) {
+					case 1:
+						return errors.New("mail server is down")
+					case 2:
+						<-ctx.Done()
+						return ctx.Err()
+					case 3:
+						return nil
+					default:
+						return errors.New("mail server is down")
+					}
+				},
+			}, reactor.WithRetries(2, time.Millisecond), reactor.WithTimeout(time.Millisecond*50)), ShouldBeNil)
+
+			processEvent, err := processor.enqueue(context.Background(), primitive.NewObjectID(), QueueFullBlock)
+			So(err, ShouldBeNil)
+			report, err := newCommitHandle(processEvent).Wait(context.Background())
+			So(err, ShouldBeNil)
+			So(report.Reactors, ShouldResemble, map[string]error{"es.testFailingReactor": nil})
+			So(atomic.LoadInt32(&attempts), ShouldEqual, 3)
+
+			letters, err := processorTestSet.deadLetters.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 0)
+
+			// once the retries are used up the event is recorded as dead letter
+			processEvent, err = processor.enqueue(context.Background(), primitive.NewObjectID(), QueueFullBlock)
+			So(err, ShouldBeNil)
+			report, err = newCommitHandle(processEvent).Wait(context.Background())
+			So(err, ShouldBeNil)
+			So(report.Reactors["es.testFailingReactor"], ShouldBeError, "reactor 'es.testFailingReactor' failed to react on event with name 'user.registered': mail server is down")
+			So(atomic.LoadInt32(&attempts), ShouldEqual, 6)
+
+			letters, err = processorTestSet.deadLetters.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 1)
+			So(letters[0].Reactor, ShouldEqual, "es.testFailingReactor")
+			So(letters[0].Error, ShouldEqual, "mail server is down")
+			So(letters[0].Attempts, ShouldEqual, 3)
+
+		})
+
+		Convey("a timed out reactor must keep its lane till it returned", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// the reactor notices the timeout late - the following attempts must not overlap with it
+			running, overlapped, attempts := int32(0), int32(0), int32(0)
+			So(processorTestSet.reactorRegistry.Register(&testFailingReactor{
+				handle: func(ctx context.Context, event event.IESEvent) error {
+					if atomic.AddInt32(&running, 1) > 1 {
+						atomic.StoreInt32(&overlapped, 1)
+					}
+					defer atomic.AddInt32(&running, -1)
+					if atomic.AddInt32(&attempts, 1) > 1 {
+						return nil
+					}
+					<-ctx.Done()
+					time.Sleep(time.Millisecond * 100)
+					return ctx.Err()
+				},
+			}, reactor.WithRetries(1, time.Millisecond), reactor.WithTimeout(time.Millisecond*10)), ShouldBeNil)
+
+			onFirstProcessed := processor.Process(primitive.NewObjectID())
+			onSecondProcessed := processor.Process(primitive.NewObjectID())
+			So(<-onFirstProcessed, ShouldResemble, struct{}{})
+			So(<-onSecondProcessed, ShouldResemble, struct{}{})
+
+			So(atomic.LoadInt32(&attempts), ShouldEqual, 3)
+			So(atomic.LoadInt32(&overlapped), ShouldEqual, 0)
+
+		})
+
+		Convey("projectors and reactors must get the values of the commit context and be cancelled on shutdown", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			type key struct{}
+
+			// register projector - it blocks on the second event till its context is cancelled
+			projectorValues := make(chan interface{}, 2)
+			cancelled := make(chan error, 1)
+			handled := 0
+			So(processorTestSet.projectorRegistry.Register(&testContextProjector{
+				testProjector: &testProjector{
+					name: "user.projector",
+					interestedInEvents: []event.IESEvent{
+						&testEvent{},
+					},
+				},
+				handleContext: func(ctx context.Context, event event.IESEvent) error {
+					projectorValues <- ctx.Value(key{})
+					handled++
+					if handled == 2 {
+						<-ctx.Done()
+						cancelled <- ctx.Err()
+						return ctx.Err()
+					}
+					return nil
+				},
+			}), ShouldBeNil)
+
+			// register reactor
+			reactorValues := make(chan interface{}, 1)
+			So(processorTestSet.reactorRegistry.Register(&testContextReactor{
+				handle: func(ctx context.Context, event event.IESEvent) {
+					reactorValues <- ctx.Value(key{})
+				},
+			}), ShouldBeNil)
+
+			// the commit context is cancelled right after the event got queued
+			commitCtx, cancelCommit := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+			processEvent, err := processor.enqueue(commitCtx, primitive.NewObjectID(), QueueFullBlock)
+			So(err, ShouldBeNil)
+			cancelCommit()
+			report, err := newCommitHandle(processEvent).Wait(context.Background())
+			So(err, ShouldBeNil)
+			So(report.Failed(), ShouldBeFalse)
+			So(<-projectorValues, ShouldEqual, "value")
+			So(<-reactorValues, ShouldEqual, "value")
+
+			// the context is cancelled once the shutdown stops waiting
+			processor.Process(primitive.NewObjectID())
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Millisecond*100)
+			defer cancelShutdown()
+			So(processor.Shutdown(shutdownCtx), ShouldResemble, context.DeadlineExceeded)
+			So(<-cancelled, ShouldResemble, context.Canceled)
+
+		})
+
+		Convey("reactors must catch up on the events they missed since their checkpoint", func() {
+
+			eventIDs := []primitive.ObjectID{
+				primitive.NewObjectID(),
+				primitive.NewObjectID(),
+				primitive.NewObjectID(),
+				primitive.NewObjectID(),
+			}
+
+			// mock event repository - the last event gets committed after the start
+			queries := make(chan event.Query, 1)
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+				mapEvents: func(query event.Query, cb func(event event.Event)) error {
+					queries <- query
+					for _, id := range eventIDs[1:3] {
+						eventID := id
+						cb(event.Event{
+							ID:   &eventID,
+							Name: "user.registered",
+						})
+					}
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register reactor
+			reacted := 0
+			So(processorTestSet.reactorRegistry.Register(&testNamedReactor{
+				name: "mailer",
+				handle: func(event event.IESEvent) {
+					reacted++
+				},
+			}), ShouldBeNil)
+
+			// the reactor reacted on the first event before the restart
+			So(processorTestSet.reactorCheckpoints.UpdateLastHandledEvent(&reactorCheckpoint{reactorName: "mailer"}, event.Event{ID: &eventIDs[0]}), ShouldBeNil)
+
+			processor.Start()
+
+			// the caught up event is processed again - the reactor must not react twice
+			So(<-processor.Process(eventIDs[2]), ShouldResemble, struct{}{})
+			So(*(<-queries).After, ShouldEqual, eventIDs[0])
+			So(reacted, ShouldEqual, 2)
+
+			So(<-processor.Process(eventIDs[3]), ShouldResemble, struct{}{})
+			So(reacted, ShouldEqual, 3)
+
+			checkpoint, err := processorTestSet.reactorCheckpoints.LastHandledEvent(&reactorCheckpoint{reactorName: "mailer"})
+			So(err, ShouldBeNil)
+			So(*checkpoint, ShouldEqual, eventIDs[3])
+
+		})
+
+		Convey("a slow reactor must not block the other reactors while catching up", func() {
+
+			eventIDs := []primitive.ObjectID{
+				primitive.NewObjectID(),
+				primitive.NewObjectID(),
+				primitive.NewObjectID(),
+				primitive.NewObjectID(),
+			}
+			versions := map[primitive.ObjectID]uint8{
+				eventIDs[0]: 1,
+				eventIDs[1]: 2,
+				eventIDs[2]: 3,
+				eventIDs[3]: 4,
+			}
+
+			// mock event repository - the last event gets committed after the start
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:      &id,
+						Name:    "user.registered",
+						Version: versions[id],
+					}, nil
+				},
+				mapEvents: func(query event.Query, cb func(event event.Event)) error {
+					for _, id := range eventIDs[1:3] {
+						eventID := id
+						cb(event.Event{
+							ID:      &eventID,
+							Name:    "user.registered",
+							Version: versions[id],
+						})
+					}
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register reactors - the slow one blocks till it got released
+			release := make(chan struct{})
+			So(processorTestSet.reactorRegistry.Register(&testContextReactor{
+				handle: func(ctx context.Context, event event.IESEvent) {
+					<-release
+				},
+			}), ShouldBeNil)
+			reacted := make(chan uint8, 3)
+			So(processorTestSet.reactorRegistry.Register(&testNamedReactor{
+				name: "mailer",
+				handle: func(event event.IESEvent) {
+					reacted <- event.Version()
+				},
+			}), ShouldBeNil)
+
+			// both reactors reacted on the first event before the restart
+			for _, reactorName := range []string{"es.testContextReactor", "mailer"} {
+				So(processorTestSet.reactorCheckpoints.UpdateLastHandledEvent(&reactorCheckpoint{reactorName: reactorName}, event.Event{ID: &eventIDs[0]}), ShouldBeNil)
+			}
+
+			processor.Start()
+			onProcessed := processor.Process(eventIDs[3])
+
+			// the mailer catches up and reacts on the new event while the slow reactor is still busy
+			So(<-reacted, ShouldEqual, 2)
+			So(<-reacted, ShouldEqual, 3)
+			So(<-reacted, ShouldEqual, 4)
+
+			close(release)
+			So(<-onProcessed, ShouldResemble, struct{}{})
+
+			checkpoint, err := processorTestSet.reactorCheckpoints.LastHandledEvent(&reactorCheckpoint{reactorName: "es.testContextReactor"})
+			So(err, ShouldBeNil)
+			So(*checkpoint, ShouldEqual, eventIDs[3])
+
+		})
+
+		Convey("slow reactors must neither block the projectors nor the commit when they are detached", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}, WithDetachedReactors())
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projector
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					return nil
+				},
+			}), ShouldBeNil)
+
+			// register reactor that blocks till it gets released
+			release := make(chan struct{})
+			So(processorTestSet.reactorRegistry.Register(&testReactor{
+				handle: func(event event.IESEvent) {
+					<-release
+				},
+			}), ShouldBeNil)
+
+			eventIDs := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+			for _, eventID := range eventIDs {
+				processEvent, err := processor.enqueue(context.Background(), eventID, QueueFullBlock)
+				So(err, ShouldBeNil)
+				report, err := newCommitHandle(processEvent).Wait(context.Background())
+				So(err, ShouldBeNil)
+				So(report.Projectors, ShouldResemble, map[string]error{"user.projector": nil})
+				So(report.Reactors, ShouldBeEmpty)
+			}
+
+			// the shutdown waits for the reactor
+			close(release)
+			So(processor.Shutdown(context.Background()), ShouldBeNil)
+
+			checkpoint, err := processorTestSet.reactorCheckpoints.LastHandledEvent(&reactorCheckpoint{reactorName: "es.testReactor"})
+			So(err, ShouldBeNil)
+			So(*checkpoint, ShouldEqual, eventIDs[1])
+
+		})
+
+		Convey("reactors must react on events of different streams concurrently and keep the order of a stream", func() {
+
+			// two streams that are handled by different lanes
+			firstStream, secondStream := "first", "second"
+			for i := 0; partition(firstStream, 2) == partition(secondStream, 2); i++ {
+				secondStream = fmt.Sprintf("second-%d", i)
+			}
+
+			eventIDs := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+			streams := map[primitive.ObjectID]string{
+				eventIDs[0]: firstStream,
+				eventIDs[1]: firstStream,
+				eventIDs[2]: secondStream,
+			}
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.renamed",
+						Payload: map[string]interface{}{
+							"stream": streams[id],
+						},
+					}, nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.renamed", testStreamEvent{}), ShouldBeNil)
+
+			// the first event of the first stream is only done once the event of the second stream got reacted on
+			reactedOn := make(chan string, 3)
+			secondStreamDone := make(chan struct{})
+			checkpointWhileBlocked := make(chan *primitive.ObjectID, 1)
+			handled := 0
+			So(processorTestSet.reactorRegistry.Register(&testStreamReactor{
+				handle: func(event testStreamEvent) {
+					if event.Payload.Stream == secondStream {
+						reactedOn <- event.Payload.Stream
+						close(secondStreamDone)
+						return
+					}
+					handled++
+					if handled == 1 {
+						<-secondStreamDone
+						checkpoint, _ := processorTestSet.reactorCheckpoints.LastHandledEvent(&reactorCheckpoint{reactorName: "es.testStreamReactor"})
+						checkpointWhileBlocked <- checkpoint
+					}
+					reactedOn <- fmt.Sprintf("%s:%d", event.Payload.Stream, handled)
+				},
+			}, reactor.WithConcurrency(2), reactor.WithStreamOrdering()), ShouldBeNil)
+
+			processed := []<-chan struct{}{}
+			for _, eventID := range eventIDs {
+				processed = append(processed, processor.Process(eventID))
+			}
+			for _, done := range processed {
+				<-done
+			}
+
+			So(<-reactedOn, ShouldEqual, secondStream)
+			So(<-reactedOn, ShouldEqual, firstStream+":1")
+			So(<-reactedOn, ShouldEqual, firstStream+":2")
+
+			// the checkpoint doesn't skip the event that is still handled
+			So(<-checkpointWhileBlocked, ShouldBeNil)
+			checkpoint, err := processorTestSet.reactorCheckpoints.LastHandledEvent(&reactorCheckpoint{reactorName: "es.testStreamReactor"})
+			So(err, ShouldBeNil)
+			So(*checkpoint, ShouldEqual, eventIDs[2])
+
+		})
+
+		Convey("follow up events of reactors must be committed with their causation and stop at the maximum causation depth", func() {
+
+			// event store that keeps the events in memory
+			lock := &sync.Mutex{}
+			events := []event.Event{}
+			eventRepo := &testEventRepository{
+				save: func(e *event.Event) error {
+					lock.Lock()
+					defer lock.Unlock()
+					for _, persisted := range events {
+						if e.IdempotencyKey != "" && persisted.IdempotencyKey == e.IdempotencyKey {
+							return event.ErrDuplicateIdempotencyKey
+						}
+					}
+					id := primitive.NewObjectID()
+					e.ID = &id
+					events = append(events, *e)
+					return nil
+				},
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					lock.Lock()
+					defer lock.Unlock()
+					for _, persisted := range events {
+						if *persisted.ID == id {
+							return persisted, nil
+						}
+					}
+					return event.Event{}, errors.New("event not found")
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{}, WithMaxCausationDepth(3))
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// the reactor triggers itself over and over again
+			reacted := make(chan struct{}, 10)
+			So(processorTestSet.reactorRegistry.Register(&testFollowUpReactor{
+				react: func(e testEvent) ([]event.IESEvent, error) {
+					reacted <- struct{}{}
+					return []event.IESEvent{testEvent{}}, nil
+				},
+			}), ShouldBeNil)
+
+			root := &event.Event{Name: "user.registered", Payload: map[string]interface{}{}}
+			So(eventRepo.Save(root), ShouldBeNil)
+			<-processor.Process(*root.ID)
+
+			// the root event and the three follow up events
+			for i := 0; i < 4; i++ {
+				<-reacted
+			}
+			So(processor.Shutdown(context.Background()), ShouldBeNil)
+
+			lock.Lock()
+			persisted := append([]event.Event{}, events...)
+			lock.Unlock()
+			So(persisted, ShouldHaveLength, 4)
+
+			So(*persisted[1].CausationID, ShouldEqual, *root.ID)
+			So(*persisted[1].CorrelationID, ShouldEqual, *root.ID)
+			So(persisted[1].CausationDepth, ShouldEqual, 1)
+			So(persisted[1].IdempotencyKey, ShouldEqual, root.ID.Hex()+":es.testFollowUpReactor:0")
+			So(*persisted[3].CausationID, ShouldEqual, *persisted[2].ID)
+			So(*persisted[3].Correlation(), ShouldEqual, *root.ID)
+			So(persisted[3].CausationDepth, ShouldEqual, 3)
+
+			// the cycle ends as dead letter
+			letters, err := processorTestSet.deadLetters.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 1)
+			So(letters[0].EventID, ShouldEqual, *persisted[3].ID)
+			So(letters[0].Reactor, ShouldEqual, "es.testFollowUpReactor")
+			So(letters[0].Error, ShouldEqual, ErrCausationLoop.Error())
+
+			// committing the follow up events again has no effect
+			So(processor.commitFollowUps("es.testFollowUpReactor", persisted[0], []event.IESEvent{testEvent{}}), ShouldBeNil)
+			lock.Lock()
+			So(events, ShouldHaveLength, 4)
+			lock.Unlock()
+
+		})
+
+		Convey("follow up events committed while shutting down must be processed before the processor stops", func() {
+
+			// event store that keeps the events in memory
+			lock := &sync.Mutex{}
+			events := []event.Event{}
+			eventRepo := &testEventRepository{
+				save: func(e *event.Event) error {
+					lock.Lock()
+					defer lock.Unlock()
+					id := primitive.NewObjectID()
+					e.ID = &id
+					events = append(events, *e)
+					return nil
+				},
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					lock.Lock()
+					defer lock.Unlock()
+					for _, persisted := range events {
+						if *persisted.ID == id {
+							return persisted, nil
+						}
+					}
+					return event.Event{}, errors.New("event not found")
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// the reactor commits a follow up event for the root event once it got released
+			started := make(chan struct{})
+			release := make(chan struct{})
+			reacted := int32(0)
+			So(processorTestSet.reactorRegistry.Register(&testFollowUpReactor{
+				react: func(e testEvent) ([]event.IESEvent, error) {
+					if atomic.AddInt32(&reacted, 1) > 1 {
+						return nil, nil
+					}
+					close(started)
+					<-release
+					return []event.IESEvent{testEvent{}}, nil
+				},
+			}), ShouldBeNil)
+
+			root := &event.Event{Name: "user.registered", Payload: map[string]interface{}{}}
+			So(eventRepo.Save(root), ShouldBeNil)
+			processor.Process(*root.ID)
+			<-started
+
+			shutdown := make(chan error, 1)
+			go func() {
+				shutdown <- processor.Shutdown(context.Background())
+			}()
+			for atomic.LoadInt32(&processor.shuttingDown) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			close(release)
+			So(<-shutdown, ShouldBeNil)
+
+			// the reactor reacted on the follow up event as well
+			So(atomic.LoadInt32(&reacted), ShouldEqual, 2)
+			letters, err := processorTestSet.deadLetters.All()
+			So(err, ShouldBeNil)
+			So(letters, ShouldHaveLength, 0)
+
+		})
+
+		Convey("the messages of outbox reactors must be stored together with their checkpoint", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, &testProjectorRepository{})
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register reactor
+			So(processorTestSet.reactorRegistry.Register(&testOutboxReactor{
+				outbox: func(event event.IESEvent) []reactor.Message {
+					return []reactor.Message{
+						{Topic: "mails", Payload: map[string]interface{}{"template": "welcome"}},
+						{Topic: "analytics"},
+					}
+				},
+			}), ShouldBeNil)
+
+			eventID := primitive.NewObjectID()
+			processEvent, err := processor.enqueue(context.Background(), eventID, QueueFullBlock)
+			So(err, ShouldBeNil)
+			report, err := newCommitHandle(processEvent).Wait(context.Background())
+			So(err, ShouldBeNil)
+			So(report.Reactors, ShouldResemble, map[string]error{"es.testOutboxReactor": nil})
+
+			messages := processorTestSet.outbox.all()
+			So(messages, ShouldHaveLength, 2)
+			So(messages[0].ID, ShouldEqual, eventID.Hex()+":es.testOutboxReactor:0")
+			So(messages[0].EventID, ShouldEqual, eventID)
+			So(messages[0].Reactor, ShouldEqual, "es.testOutboxReactor")
+			So(messages[0].Topic, ShouldEqual, "mails")
+			So(messages[0].Payload, ShouldResemble, map[string]interface{}{"template": "welcome"})
+			So(messages[1].Topic, ShouldEqual, "analytics")
+
+			checkpoint, err := processorTestSet.reactorCheckpoints.LastHandledEvent(&reactorCheckpoint{reactorName: "es.testOutboxReactor"})
+			So(err, ShouldBeNil)
+			So(*checkpoint, ShouldEqual, eventID)
+
+		})
+
+		Convey("shutdown right after start must drain the queued events", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// the processor picks the start or the shutdown signal at random in case both are there
+			for i := 0; i < 20; i++ {
+
+				// projector repository
+				checkpoints := make(chan primitive.ObjectID, 1)
+				projectorRepository := &testProjectorRepository{
+					outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+						return 1, nil
+					},
+					updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+						checkpoints <- *event.ID
+						return nil
+					},
+				}
+
+				// create new processor
+				processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+				So(err, ShouldBeNil)
+				processor := processorTestSet.processor
+
+				So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+				So(processorTestSet.projectorRegistry.Register(&testProjector{
+					name: "user.projector",
+					interestedInEvents: []event.IESEvent{
+						&testEvent{},
+					},
+					handleEvent: func(event event.IESEvent) error {
+						return nil
+					},
+				}), ShouldBeNil)
+
+				_, err = processor.ProcessContext(context.Background(), primitive.NewObjectID())
+				So(err, ShouldBeNil)
+
+				processor.Start()
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				So(processor.Shutdown(ctx), ShouldBeNil)
+				cancel()
+
+				So(checkpoints, ShouldHaveLength, 1)
+
+			}
+
+		})
+
+		Convey("pausing a processor that shut down or stopped must fail", func() {
+
+			for _, shutdown := range []bool{true, false} {
+
+				processorTestSet, err := newProcessorTestSet(false, &testEventRepository{}, &testProjectorRepository{})
+				So(err, ShouldBeNil)
+				processor := processorTestSet.processor
+				processor.Start()
+
+				if shutdown {
+					So(processor.Shutdown(context.Background()), ShouldBeNil)
+				} else {
+					processor.Stop()
+				}
+
+				paused := make(chan error, 1)
+				go func() {
+					paused <- processor.pause()
+				}()
+
+				select {
+				case err := <-paused:
+					So(err, ShouldEqual, ErrShutdown)
+				case <-time.After(time.Second):
+					So("pause blocked", ShouldBeEmpty)
+				}
+
+			}
+
+		})
+
+		Convey("start and shutdown at the same time must not block", func() {
+
+			for i := 0; i < 20; i++ {
+
+				processorTestSet, err := newProcessorTestSet(false, &testEventRepository{}, &testProjectorRepository{})
+				So(err, ShouldBeNil)
+				processor := processorTestSet.processor
+
+				started := make(chan struct{})
+				go func() {
+					processor.Start()
+					close(started)
+				}()
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				So(processor.Shutdown(ctx), ShouldBeNil)
+				cancel()
+				<-started
+
+			}
+
+		})
+
+		Convey("buffer events while a replay is running and skip the replayed projectors on hand over", func() {
+
+			eventID := primitive.NewObjectID()
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &eventID,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+
+			// the replay lock is held till we release it
+			lock := &atomic.Value{}
+			lock.Store(&replayLock{
+				Locked:    true,
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			})
+			processor := processorTestSet.processor
+			processor.lockRepository = &testReplayLockRepository{
+				fetch: func() (*replayLock, error) {
+					return lock.Load().(*replayLock), nil
+				},
+			}
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// register projectors
+			handledByUserProjector := make(chan struct{}, 1)
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					handledByUserProjector <- struct{}{}
+					return nil
+				},
+			}), ShouldBeNil)
+			handledByAccountProjector := make(chan struct{}, 1)
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "account.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					handledByAccountProjector <- struct{}{}
+					return nil
+				},
+			}), ShouldBeNil)
+
+			onProcessed := processor.Process(eventID)
+
+			// the event must not be processed while the replay is running
+			select {
+			case <-onProcessed:
+				panic("didn't expect event to be processed while the replay is running")
+			case <-time.After(time.Second * 2):
+			}
+
+			// release the lock - the replay applied the event to the user projector
+			lock.Store(&replayLock{
+				Locked: false,
+				Handover: &replayHandover{
+					Event:      &eventID,
+					Projectors: []string{"user.projector"},
+				},
+			})
+
+			So(<-onProcessed, ShouldResemble, struct{}{})
+			So(handledByAccountProjector, ShouldHaveLength, 1)
+			So(handledByUserProjector, ShouldHaveLength, 0)
+
+		})
+
+		Convey("pausing must wait till the projectors finished the events handed over to them", func() {
+
+			eventID := primitive.NewObjectID()
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &eventID,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+			processor := processorTestSet.processor
+			processor.Start()
+
+			// register event
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+
+			// slow projector
+			handling := make(chan struct{})
+			release := make(chan struct{})
+			handled := int32(0)
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					close(handling)
+					<-release
+					atomic.StoreInt32(&handled, 1)
+					return nil
+				},
+			}), ShouldBeNil)
+
+			onProcessed := processor.Process(eventID)
+			<-handling
+
+			paused := make(chan error, 1)
+			go func() {
+				paused <- processor.pause()
+			}()
+
+			// the projector is still busy
+			select {
+			case <-paused:
+				panic("didn't expect the pause to be acknowledged while the projector is busy")
+			case <-time.After(time.Millisecond * 500):
+			}
+
+			close(release)
+			So(<-paused, ShouldBeNil)
+			So(atomic.LoadInt32(&handled), ShouldEqual, 1)
+			So(<-onProcessed, ShouldResemble, struct{}{})
+
+		})
+
+		Convey("poll the replay lock instead of fetching it for every event", func() {
+
+			// mock event repository
+			eventRepo := &testEventRepository{
+				fetchByID: func(id primitive.ObjectID) (event.Event, error) {
+					return event.Event{
+						ID:   &id,
+						Name: "user.registered",
+					}, nil
+				},
+			}
+
+			// projector repository
+			projectorRepository := &testProjectorRepository{
+				outOfSyncBy: func(projector projector.IProjector) (i int64, e error) {
+					return 1, nil
+				},
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+
+			// create new processor
+			processorTestSet, err := newProcessorTestSet(false, eventRepo, projectorRepository)
+			So(err, ShouldBeNil)
+
+			// count the fetches of the replay lock
+			fetches := int32(0)
+			lock := &atomic.Value{}
+			lock.Store(&replayLock{})
+			processor := processorTestSet.processor
+			processor.lockRepository = &testReplayLockRepository{
+				fetch: func() (*replayLock, error) {
+					atomic.AddInt32(&fetches, 1)
+					return lock.Load().(*replayLock), nil
+				},
+			}
+			processor.Start()
+
+			// register event and projector
+			So(processorTestSet.eventRegistry.RegisterEvent("user.registered", testEvent{}), ShouldBeNil)
+			handled := make(chan struct{}, 10)
+			So(processorTestSet.projectorRegistry.Register(&testProjector{
+				name: "user.projector",
+				interestedInEvents: []event.IESEvent{
+					&testEvent{},
+				},
+				handleEvent: func(event event.IESEvent) error {
+					handled <- struct{}{}
+					return nil
+				},
+			}), ShouldBeNil)
+
+			// the lock got fetched once on start
+			for i := 0; i < 5; i++ {
+				So(<-processor.Process(primitive.NewObjectID()), ShouldResemble, struct{}{})
+			}
+			So(atomic.LoadInt32(&fetches), ShouldBeLessThanOrEqualTo, 2)
+
+			// the processor pauses once it noticed the replay
+			lock.Store(&replayLock{
+				Locked:    true,
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			})
+			time.Sleep(replayLockPollInterval * 2)
+
+			onProcessed := processor.Process(primitive.NewObjectID())
+			select {
+			case <-onProcessed:
+				panic("didn't expect event to be processed while the replay is running")
+			case <-time.After(time.Millisecond * 500):
+			}
+
+			// release the lock
+			lock.Store(&replayLock{})
+			So(<-onProcessed, ShouldResemble, struct{}{})
+			So(handled, ShouldHaveLength, 6)
+
+		})
+
 	})
 
 }
@@ -0,0 +1,77 @@
+package projector
+
+import (
+	"context"
+	"github.com/florianlenz/event-sourcing-go/internal/retry"
+	"time"
+)
+
+// what happens once a projector finally failed to handle an event
+type FailureAction int
+
+const (
+	// log the error - the checkpoint isn't moved so the following events fail as out of sync
+	OnFailureLog FailureAction = iota
+	// log the error, move the checkpoint past the event and continue with the next event
+	OnFailurePark
+	// stop the projector - it doesn't handle any further events till the processor is restarted
+	OnFailureHalt
+)
+
+// defines how a projector deals with errors returned by Handle
+type ErrorPolicy struct {
+	// amount of retries before the failure is final
+	Retries int
+	// time to wait before the first retry - doubled with every further retry
+	Backoff time.Duration
+	// what to do once all retries failed
+	OnFailure FailureAction
+}
+
+type RegisterOption func(policy *ErrorPolicy)
+
+// retry handling the event the given amount of times with exponential backoff (starting with the given backoff)
+func WithRetries(retries int, backoff time.Duration) RegisterOption {
+	return func(policy *ErrorPolicy) {
+		policy.Retries = retries
+		policy.Backoff = backoff
+	}
+}
+
+// park events the projector failed to handle and continue with the next event
+func WithParkOnFailure() RegisterOption {
+	return func(policy *ErrorPolicy) {
+		policy.OnFailure = OnFailurePark
+	}
+}
+
+// halt the projector once it failed to handle an event
+func WithHaltOnFailure() RegisterOption {
+	return func(policy *ErrorPolicy) {
+		policy.OnFailure = OnFailureHalt
+	}
+}
+
+// Run fn and retry it according to the policy till it succeeds. Stops waiting for the next attempt once the context is
+// done. The error of the last attempt is returned.
+func (p ErrorPolicy) Retry(ctx context.Context, fn func() error) error {
+	return retry.Do(ctx, p.Retries, p.Backoff, fn)
+}
+
+func newErrorPolicy(options ...RegisterOption) ErrorPolicy {
+
+	policy := ErrorPolicy{
+		OnFailure: OnFailureLog,
+	}
+
+	for _, option := range options {
+		option(&policy)
+	}
+
+	if policy.Retries < 0 {
+		policy.Retries = 0
+	}
+
+	return policy
+
+}
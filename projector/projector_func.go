@@ -0,0 +1,67 @@
+package projector
+
+import (
+	"context"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"reflect"
+)
+
+// a function that handles one type of event - see On
+type Handler struct {
+	expectedEvent reflect.Type
+	handle        handle
+}
+
+// Handle the events of type E with the given function
+func On[E event.IESEvent](handle func(ctx context.Context, e E) error) Handler {
+
+	expectedEvent := reflect.TypeOf((*E)(nil)).Elem()
+
+	return Handler{
+		expectedEvent: expectedEvent,
+		handle: func(ctx context.Context, e event.IESEvent) error {
+
+			typedEvent, k := e.(E)
+			if !k {
+				return fmt.Errorf("handler expects '%s' but got '%T'", expectedEvent.Name(), e)
+			}
+
+			return handle(ctx, typedEvent)
+
+		},
+	}
+
+}
+
+// Create a projector with the given name that handles the events of type E with the given function. The projector is
+// interested in the events of type E, which must be a concrete event type.
+func Func[E event.IESEvent](name string, handle func(ctx context.Context, e E) error) (IProjector, error) {
+	return Funcs(name, On[E](handle))
+}
+
+// Create a projector with the given name that handles each type of event with its own function (see On). The
+// projector is interested in the events of the functions.
+func Funcs(name string, handlers ...Handler) (IProjector, error) {
+
+	p := newDispatchProjector(name)
+
+	for _, handler := range handlers {
+
+		if handler.expectedEvent.Kind() == reflect.Interface {
+			return nil, fmt.Errorf("the handlers of projector %s must handle concrete event types", name)
+		}
+
+		if err := p.add(handler.expectedEvent, handler.handle); err != nil {
+			return nil, err
+		}
+
+	}
+
+	if len(p.handlers) == 0 {
+		return nil, fmt.Errorf("projector %s doesn't have any handlers", name)
+	}
+
+	return p, nil
+
+}
@@ -0,0 +1,85 @@
+package projector
+
+import (
+	"context"
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/event"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestFuncProjector(t *testing.T) {
+
+	Convey("func projector", t, func() {
+
+		Convey("project one type of event with a function", func() {
+
+			created := []string{}
+			projector, err := Func("user-count", func(ctx context.Context, e testEventUserCreated) error {
+				created = append(created, ctx.Value(userKey{}).(string))
+				return nil
+			})
+			So(err, ShouldBeNil)
+
+			So(projector.Name(), ShouldEqual, "user-count")
+			So(projector.InterestedInEvents(), ShouldResemble, []event.IESEvent{testEventUserCreated{}})
+
+			ctx := context.WithValue(context.Background(), userKey{}, "1")
+			So(projector.(IContextProjector).HandleContext(ctx, testEventUserCreated{}), ShouldBeNil)
+			So(projector.Handle(testEventUserUpdated{}), ShouldBeError, "projector 'user-count' doesn't have a method for event 'testEventUserUpdated'")
+
+			So(created, ShouldResemble, []string{"1"})
+
+		})
+
+		Convey("validate the functions", func() {
+
+			_, err := Funcs("users")
+			So(err, ShouldBeError, "projector users doesn't have any handlers")
+
+			_, err = Funcs("users",
+				On(func(ctx context.Context, e testEventUserCreated) error { return nil }),
+				On(func(ctx context.Context, e *testEventUserCreated) error { return nil }),
+			)
+			So(err, ShouldBeError, "projector users has multiple methods for event 'testEventUserCreated'")
+
+			_, err = Funcs("users", On(func(ctx context.Context, e event.IESEvent) error { return nil }))
+			So(err, ShouldBeError, "the handlers of projector users must handle concrete event types")
+
+			_, err = Func("users", func(ctx context.Context, e event.IESEvent) error { return nil })
+			So(err, ShouldBeError, "the handlers of projector users must handle concrete event types")
+
+		})
+
+		Convey("project multiple types of events with a function per type", func() {
+
+			handled := []string{}
+			projector, err := Funcs("users",
+				On(func(ctx context.Context, e testEventUserCreated) error {
+					handled = append(handled, "created")
+					return nil
+				}),
+				On(func(ctx context.Context, e *testEventUserDeleted) error {
+					handled = append(handled, "deleted")
+					return errors.New("user is still referenced")
+				}),
+			)
+			So(err, ShouldBeNil)
+
+			So(projector.InterestedInEvents(), ShouldResemble, []event.IESEvent{&testEventUserDeleted{}, testEventUserCreated{}})
+
+			// the projector is found by the events of its functions
+			registry := NewProjectorRegistry()
+			So(registry.Register(projector), ShouldBeNil)
+			So(registry.ProjectorsForEvent(testEventUserDeleted{}), ShouldHaveLength, 1)
+
+			So(projector.Handle(testEventUserCreated{}), ShouldBeNil)
+			So(projector.Handle(&testEventUserDeleted{}), ShouldBeError, "user is still referenced")
+
+			So(handled, ShouldResemble, []string{"created", "deleted"})
+
+		})
+
+	})
+
+}
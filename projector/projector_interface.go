@@ -1,6 +1,9 @@
 package projector
 
-import "github.com/florianlenz/event-sourcing-go/event"
+import (
+	"context"
+	"github.com/florianlenz/event-sourcing-go/event"
+)
 
 type IProjector interface {
 	// unique name of the projector
@@ -10,3 +13,50 @@ type IProjector interface {
 	// handle a given event sourcing event
 	Handle(event event.IESEvent) error
 }
+
+// Projectors that can apply multiple events at once (e.g. with a bulk write) can implement this interface.
+// Consecutive events are then handed over together and the checkpoint of the projector is updated once per batch.
+type IBatchProjector interface {
+	IProjector
+	// handle the given events - they are in the order they got committed
+	HandleBatch(events []event.IESEvent) error
+}
+
+// The events of a partitioned projector are handled by multiple workers. Events with the same partition key are
+// handled in order while events with different keys are handled concurrently.
+type IPartitionedProjector interface {
+	IProjector
+	// amount of workers
+	Partitions() int
+}
+
+// Partitioned projectors can implement this interface to provide the partition key of an event.
+// The stream id is used for events that implement IStreamEvent if a projector doesn't provide a key.
+type IPartitionKeyProvider interface {
+	// partition key of the event
+	PartitionKey(event event.IESEvent) string
+}
+
+// get the partition key of the event for the given projector
+func PartitionKey(projector IProjector, e event.IESEvent) string {
+
+	if keyProvider, k := projector.(IPartitionKeyProvider); k {
+		return keyProvider.PartitionKey(e)
+	}
+
+	if streamEvent, k := e.(event.IStreamEvent); k {
+		return streamEvent.StreamID()
+	}
+
+	return ""
+
+}
+
+// Projectors that need a context (e.g. to pass it on to the database) can implement this interface. HandleContext is
+// used instead of Handle. The context carries the values of the commit context and is cancelled once the processor
+// stops waiting for the projector (e.g. when the shutdown deadline expired).
+type IContextProjector interface {
+	IProjector
+	// handle the event with the given context
+	HandleContext(ctx context.Context, event event.IESEvent) error
+}
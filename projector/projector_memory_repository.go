@@ -0,0 +1,119 @@
+package projector
+
+import (
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"sync"
+)
+
+// projector repository that keeps the projector checkpoints in memory
+type memoryProjectorRepository struct {
+	lock              *sync.Mutex
+	lastHandledEvents map[string]primitive.ObjectID
+	eventRepository   event.IEventRepository
+	eventRegistry     *event.Registry
+}
+
+func (r *memoryProjectorRepository) UpdateLastHandledEvent(projector IProjector, event event.Event) error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	r.lastHandledEvents[projector.Name()] = *event.ID
+
+	return nil
+
+}
+
+func (r *memoryProjectorRepository) LastHandledEvent(projector IProjector) (*primitive.ObjectID, error) {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	lastHandledEvent, exists := r.lastHandledEvents[projector.Name()]
+	if !exists {
+		return nil, nil
+	}
+
+	return &lastHandledEvent, nil
+
+}
+
+func (r *memoryProjectorRepository) Drop() error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	r.lastHandledEvents = map[string]primitive.ObjectID{}
+
+	return nil
+
+}
+
+func (r *memoryProjectorRepository) Reset(projector IProjector) error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	delete(r.lastHandledEvents, projector.Name())
+
+	return nil
+
+}
+
+func (r *memoryProjectorRepository) OutOfSyncBy(p IProjector) (int64, error) {
+
+	// event names that the projector subscribed to
+	eventNames := []string{}
+	for _, e := range p.InterestedInEvents() {
+		eventName, err := r.eventRegistry.GetEventName(e)
+		if err != nil {
+			return 0, err
+		}
+		eventNames = append(eventNames, eventName)
+	}
+
+	// a projector without events can't be out of sync - an empty name filter would match all events
+	if len(eventNames) == 0 {
+		return 0, nil
+	}
+
+	query := event.Query{
+		Names: eventNames,
+	}
+
+	// lock
+	r.lock.Lock()
+	lastHandledEvent, exists := r.lastHandledEvents[p.Name()]
+	r.lock.Unlock()
+
+	if exists {
+		query.After = &lastHandledEvent
+	}
+
+	return r.eventRepository.Count(query)
+
+}
+
+// create a projector repository that keeps the projector checkpoints in memory. The event repository is used to
+// figure out if a projector is out of sync.
+func NewMemoryProjectorRepository(eventRepository event.IEventRepository, eventRegistry *event.Registry) *memoryProjectorRepository {
+	return &memoryProjectorRepository{
+		lock:              &sync.Mutex{},
+		lastHandledEvents: map[string]primitive.ObjectID{},
+		eventRepository:   eventRepository,
+		eventRegistry:     eventRegistry,
+	}
+}
@@ -0,0 +1,124 @@
+package projector
+
+import (
+	"context"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// test event repository
+type testEventRepository struct {
+	count func(query event.Query) (int64, error)
+}
+
+func (r *testEventRepository) Save(event *event.Event) error {
+	panic("not supposed to save events")
+}
+
+func (r *testEventRepository) SaveContext(ctx context.Context, event *event.Event) error {
+	panic("not supposed to save events")
+}
+
+func (r *testEventRepository) FetchByIdempotencyKey(ctx context.Context, key string) (event.Event, error) {
+	panic("not supposed to fetch events")
+}
+
+func (r *testEventRepository) FetchByID(id primitive.ObjectID) (event.Event, error) {
+	panic("not supposed to fetch events")
+}
+
+func (r *testEventRepository) Map(query event.Query, cb func(event event.Event)) error {
+	panic("not supposed to map events")
+}
+
+func (r *testEventRepository) Count(query event.Query) (int64, error) {
+	return r.count(query)
+}
+
+func TestMemoryProjectorRepository(t *testing.T) {
+
+	Convey("memory projector repository", t, func() {
+
+		// event registry
+		eventRegistry := event.NewEventRegistry()
+		So(eventRegistry.RegisterEvent("user.created", testEventUserCreated{}), ShouldBeNil)
+
+		// projector
+		userProjector := &testProjector{
+			name: "user.projector",
+			interestedInEvents: []event.IESEvent{
+				testEventUserCreated{},
+			},
+		}
+
+		// event repository that reports the queries it got called with
+		queries := make(chan event.Query, 1)
+		eventRepository := &testEventRepository{
+			count: func(query event.Query) (int64, error) {
+				queries <- query
+				return 3, nil
+			},
+		}
+
+		projectorRepository := NewMemoryProjectorRepository(eventRepository, eventRegistry)
+
+		Convey("count all events of a projector that never handled an event", func() {
+
+			outOfSyncBy, err := projectorRepository.OutOfSyncBy(userProjector)
+			So(err, ShouldBeNil)
+			So(outOfSyncBy, ShouldEqual, 3)
+
+			So(<-queries, ShouldResemble, event.Query{
+				Names: []string{"user.created"},
+			})
+
+		})
+
+		Convey("count the events after the last handled event", func() {
+
+			eventID := primitive.NewObjectID()
+			So(projectorRepository.UpdateLastHandledEvent(userProjector, event.Event{ID: &eventID}), ShouldBeNil)
+
+			_, err := projectorRepository.OutOfSyncBy(userProjector)
+			So(err, ShouldBeNil)
+
+			So(<-queries, ShouldResemble, event.Query{
+				Names: []string{"user.created"},
+				After: &eventID,
+			})
+
+		})
+
+		Convey("reset projector", func() {
+
+			eventID := primitive.NewObjectID()
+			So(projectorRepository.UpdateLastHandledEvent(userProjector, event.Event{ID: &eventID}), ShouldBeNil)
+			So(projectorRepository.Reset(userProjector), ShouldBeNil)
+
+			_, err := projectorRepository.OutOfSyncBy(userProjector)
+			So(err, ShouldBeNil)
+
+			So((<-queries).After, ShouldBeNil)
+
+		})
+
+		Convey("last handled event", func() {
+
+			lastHandledEvent, err := projectorRepository.LastHandledEvent(userProjector)
+			So(err, ShouldBeNil)
+			So(lastHandledEvent, ShouldBeNil)
+
+			eventID := primitive.NewObjectID()
+			So(projectorRepository.UpdateLastHandledEvent(userProjector, event.Event{ID: &eventID}), ShouldBeNil)
+
+			lastHandledEvent, err = projectorRepository.LastHandledEvent(userProjector)
+			So(err, ShouldBeNil)
+			So(*lastHandledEvent, ShouldEqual, eventID)
+
+		})
+
+	})
+
+}
@@ -0,0 +1,174 @@
+package projector
+
+import (
+	"context"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/internal/dispatch"
+	"reflect"
+	"sort"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+type handle = func(ctx context.Context, e event.IESEvent) error
+
+// projector that handles each type of event with its own handler
+type dispatchProjector struct {
+	name string
+	// the handlers by the type of event they handle
+	handlers map[reflect.Type]handle
+	events   []event.IESEvent
+}
+
+func (p *dispatchProjector) Name() string {
+	return p.name
+}
+
+func (p *dispatchProjector) InterestedInEvents() []event.IESEvent {
+	return p.events
+}
+
+func (p *dispatchProjector) Handle(e event.IESEvent) error {
+	return p.HandleContext(context.Background(), e)
+}
+
+// hand the event over to the handler of its type
+func (p *dispatchProjector) HandleContext(ctx context.Context, e event.IESEvent) error {
+
+	// event type
+	eventType := reflect.TypeOf(e)
+	if eventType.Kind() == reflect.Ptr {
+		eventType = eventType.Elem()
+	}
+
+	handle, exists := p.handlers[eventType]
+	if !exists {
+		return fmt.Errorf("projector '%s' doesn't have a method for event '%s'", p.name, eventType.Name())
+	}
+
+	return handle(ctx, e)
+
+}
+
+// add the handler for the given event type (which is the type of the events a method or function expects)
+func (p *dispatchProjector) add(expectedEvent reflect.Type, handle handle) error {
+
+	// an instance of the expected event
+	eventType := expectedEvent
+	instance := reflect.New(eventType).Elem()
+	if eventType.Kind() == reflect.Ptr {
+		eventType = eventType.Elem()
+		instance = reflect.New(eventType)
+	}
+
+	if _, exists := p.handlers[eventType]; exists {
+		return fmt.Errorf("projector %s has multiple methods for event '%s'", p.name, eventType.Name())
+	}
+
+	p.handlers[eventType] = handle
+	p.events = append(p.events, instance.Interface().(event.IESEvent))
+
+	// the interested events are ordered by their name
+	sort.Slice(p.events, func(i, j int) bool {
+		return reflect.TypeOf(p.events[i]).String() < reflect.TypeOf(p.events[j]).String()
+	})
+
+	return nil
+
+}
+
+func newDispatchProjector(name string) *dispatchProjector {
+	return &dispatchProjector{
+		name:     name,
+		handlers: map[reflect.Type]handle{},
+		events:   []event.IESEvent{},
+	}
+}
+
+// Create a projector with the given name that handles its events with the methods of the target. Every method whose
+// name is 'On' followed by an uppercase letter (e.g. 'OnUserCreated(UserCreated) error') and that expects an event
+// handles that event - it can take a context as first parameter and may return an error. Other methods (e.g. 'Online()'
+// or 'OnCall() bool') are skipped. The projector is interested in the events of the handlers.
+func NewMethodProjector(name string, target interface{}) (IProjector, error) {
+
+	targetValue := reflect.ValueOf(target)
+	targetType := targetValue.Type()
+
+	p := newDispatchProjector(name)
+
+	for i := 0; i < targetType.NumMethod(); i++ {
+
+		method := targetType.Method(i)
+		expectedEvent, err := handlerEvent(name, method)
+		if err != nil {
+			return nil, err
+		}
+		if expectedEvent == nil {
+			continue
+		}
+
+		// the context is passed in case the method wants it
+		methodValue := targetValue.Method(i)
+		err = p.add(expectedEvent, func(ctx context.Context, e event.IESEvent) error {
+
+			arguments := []reflect.Value{reflect.ValueOf(e)}
+			if methodValue.Type().NumIn() == 2 {
+				arguments = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, arguments...)
+			}
+
+			results := methodValue.Call(arguments)
+			if len(results) == 0 || results[0].IsNil() {
+				return nil
+			}
+
+			return results[0].Interface().(error)
+
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	}
+
+	if len(p.handlers) == 0 {
+		return nil, fmt.Errorf("projector %s doesn't have any 'On' methods", name)
+	}
+
+	return p, nil
+
+}
+
+// The event the method of the projector with the given name handles - nil in case the method is no handler. Handlers
+// are named 'On' followed by an uppercase letter and expect an event (optionally after a context). They must not
+// return anything but an error.
+func handlerEvent(name string, method reflect.Method) (reflect.Type, error) {
+
+	if !dispatch.IsOnMethod(method.Name) || !dispatch.TakesEvent(method) {
+		return nil, nil
+	}
+
+	// the receiver is counted as parameter too
+	parameters := method.Type.NumIn()
+	if parameters != 2 && parameters != 3 {
+		return nil, fmt.Errorf("the '%s' method of projector %s must expect exactly one event", method.Name, name)
+	}
+
+	if parameters == 3 && method.Type.In(1) != contextType {
+		return nil, fmt.Errorf("the '%s' method of projector %s must expect a context as first parameter", method.Name, name)
+	}
+
+	if method.Type.NumOut() > 1 || method.Type.NumOut() == 1 && method.Type.Out(0) != errorType {
+		return nil, fmt.Errorf("the '%s' method of projector %s may only return an error", method.Name, name)
+	}
+
+	expectedEvent := method.Type.In(parameters - 1)
+	if !expectedEvent.Implements(reflect.TypeOf((*event.IESEvent)(nil)).Elem()) {
+		return nil, fmt.Errorf("the '%s' method of projector %s expects '%s' which is not an IESImplementation", method.Name, name, expectedEvent.Name())
+	}
+
+	return expectedEvent, nil
+
+}
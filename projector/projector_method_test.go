@@ -0,0 +1,112 @@
+package projector
+
+import (
+	"context"
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/event"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type userKey struct{}
+
+// test target that handles the user events with its methods
+type testUserProjection struct {
+	handled []string
+}
+
+func (p *testUserProjection) OnUserCreated(e testEventUserCreated) error {
+	p.handled = append(p.handled, "created")
+	return nil
+}
+
+func (p *testUserProjection) OnUserDeleted(ctx context.Context, e *testEventUserDeleted) error {
+	p.handled = append(p.handled, "deleted:"+ctx.Value(userKey{}).(string))
+	return errors.New("user is still referenced")
+}
+
+// the helpers aren't handlers
+func (p *testUserProjection) Reset() {}
+
+func (p *testUserProjection) Online() bool {
+	return true
+}
+
+func (p *testUserProjection) Options(e testEventUserCreated) []string {
+	return nil
+}
+
+func (p *testUserProjection) OnCall() bool {
+	return true
+}
+
+// test target with two methods for the same event
+type testAmbiguousProjection struct {
+}
+
+func (p *testAmbiguousProjection) OnUserCreated(e testEventUserCreated) {}
+
+func (p *testAmbiguousProjection) OnUserRegistered(e testEventUserCreated) {}
+
+// test target with a method that returns something else than an error
+type testProjectionWithInvalidResult struct {
+}
+
+func (p *testProjectionWithInvalidResult) OnUserCreated(e testEventUserCreated) string {
+	return ""
+}
+
+// test target that expects the context after the event
+type testProjectionWithContextInWrongPlace struct {
+}
+
+func (p *testProjectionWithContextInWrongPlace) OnUserCreated(e testEventUserCreated, ctx context.Context) {
+}
+
+func TestMethodProjector(t *testing.T) {
+
+	Convey("method projector", t, func() {
+
+		Convey("validate the methods of the target", func() {
+
+			_, err := NewMethodProjector("users", &testEventUserUpdated{})
+			So(err, ShouldBeError, "projector users doesn't have any 'On' methods")
+
+			_, err = NewMethodProjector("users", &testAmbiguousProjection{})
+			So(err, ShouldBeError, "projector users has multiple methods for event 'testEventUserCreated'")
+
+			_, err = NewMethodProjector("users", &testProjectionWithInvalidResult{})
+			So(err, ShouldBeError, "the 'OnUserCreated' method of projector users may only return an error")
+
+			_, err = NewMethodProjector("users", &testProjectionWithContextInWrongPlace{})
+			So(err, ShouldBeError, "the 'OnUserCreated' method of projector users must expect a context as first parameter")
+
+		})
+
+		Convey("derive the interested events and dispatch the events to the methods", func() {
+
+			target := &testUserProjection{}
+			projector, err := NewMethodProjector("users", target)
+			So(err, ShouldBeNil)
+
+			So(projector.Name(), ShouldEqual, "users")
+			So(projector.InterestedInEvents(), ShouldResemble, []event.IESEvent{&testEventUserDeleted{}, testEventUserCreated{}})
+
+			// the projector is found by the events of its methods
+			registry := NewProjectorRegistry()
+			So(registry.Register(projector), ShouldBeNil)
+			So(registry.ProjectorsForEvent(testEventUserCreated{}), ShouldHaveLength, 1)
+			So(registry.ProjectorsForEvent(testEventUserUpdated{}), ShouldHaveLength, 0)
+
+			So(projector.Handle(testEventUserCreated{}), ShouldBeNil)
+			ctx := context.WithValue(context.Background(), userKey{}, "1")
+			So(projector.(IContextProjector).HandleContext(ctx, &testEventUserDeleted{}), ShouldBeError, "user is still referenced")
+			So(projector.Handle(testEventUserUpdated{}), ShouldBeError, "projector 'users' doesn't have a method for event 'testEventUserUpdated'")
+
+			So(target.handled, ShouldResemble, []string{"created", "deleted:1"})
+
+		})
+
+	})
+
+}
@@ -4,16 +4,18 @@ import (
 	"fmt"
 	"github.com/florianlenz/event-sourcing-go/event"
 	"reflect"
+	"sort"
 	"sync"
 )
 
 type Registry struct {
 	lock       *sync.Mutex
 	projectors map[string]IProjector
+	policies   map[string]ErrorPolicy
 }
 
-// register an projector
-func (r *Registry) Register(projector IProjector) error {
+// register an projector - the options define how the projector deals with errors
+func (r *Registry) Register(projector IProjector, options ...RegisterOption) error {
 
 	// lock
 	r.lock.Lock()
@@ -28,11 +30,30 @@ func (r *Registry) Register(projector IProjector) error {
 	}
 
 	r.projectors[projector.Name()] = projector
+	r.policies[projector.Name()] = newErrorPolicy(options...)
 
 	return nil
 
 }
 
+// the error policy the projector got registered with
+func (r *Registry) ErrorPolicy(projector IProjector) ErrorPolicy {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	policy, exists := r.policies[projector.Name()]
+	if !exists {
+		return newErrorPolicy()
+	}
+
+	return policy
+
+}
+
 func (r *Registry) ProjectorsForEvent(event event.IESEvent) []IProjector {
 
 	// lock
@@ -71,11 +92,34 @@ func (r *Registry) ProjectorsForEvent(event event.IESEvent) []IProjector {
 
 }
 
+// all registered projectors (ordered by their name)
+func (r *Registry) Projectors() []IProjector {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	projectors := []IProjector{}
+	for _, proj := range r.projectors {
+		projectors = append(projectors, proj)
+	}
+
+	sort.Slice(projectors, func(i, j int) bool {
+		return projectors[i].Name() < projectors[j].Name()
+	})
+
+	return projectors
+
+}
+
 func NewProjectorRegistry() *Registry {
 
 	return &Registry{
 		lock:       &sync.Mutex{},
 		projectors: map[string]IProjector{},
+		policies:   map[string]ErrorPolicy{},
 	}
 
 }
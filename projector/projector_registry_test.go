@@ -1,9 +1,12 @@
 package projector
 
 import (
+	"context"
+	"errors"
 	"github.com/florianlenz/event-sourcing-go/event"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 // test projector
@@ -123,6 +126,68 @@ func TestProjectorRegistry(t *testing.T) {
 
 		})
 
+		Convey("fetch all projectors ordered by name", func() {
+
+			registry := NewProjectorRegistry()
+
+			So(registry.Register(&testProjector{name: "user.projector"}), ShouldBeNil)
+			So(registry.Register(&testProjector{name: "account.projector"}), ShouldBeNil)
+
+			projectors := registry.Projectors()
+
+			So(projectors, ShouldHaveLength, 2)
+			So(projectors[0].Name(), ShouldEqual, "account.projector")
+			So(projectors[1].Name(), ShouldEqual, "user.projector")
+
+		})
+
+		Convey("error policy", func() {
+
+			registry := NewProjectorRegistry()
+
+			So(registry.Register(&testProjector{name: "user.projector"}), ShouldBeNil)
+			So(registry.Register(&testProjector{name: "account.projector"}, WithRetries(3, time.Second), WithHaltOnFailure()), ShouldBeNil)
+
+			// the error is logged by default
+			So(registry.ErrorPolicy(&testProjector{name: "user.projector"}), ShouldResemble, ErrorPolicy{
+				OnFailure: OnFailureLog,
+			})
+
+			So(registry.ErrorPolicy(&testProjector{name: "account.projector"}), ShouldResemble, ErrorPolicy{
+				Retries:   3,
+				Backoff:   time.Second,
+				OnFailure: OnFailureHalt,
+			})
+
+		})
+
+		Convey("retry with exponential backoff", func() {
+
+			policy := ErrorPolicy{
+				Retries: 2,
+				Backoff: time.Millisecond * 10,
+			}
+
+			attempts := []time.Time{}
+			err := policy.Retry(context.Background(), func() error {
+				attempts = append(attempts, time.Now())
+				return errors.New("failed")
+			})
+			So(err, ShouldBeError, "failed")
+			So(attempts, ShouldHaveLength, 3)
+			So(attempts[1].Sub(attempts[0]), ShouldBeGreaterThanOrEqualTo, time.Millisecond*10)
+			So(attempts[2].Sub(attempts[1]), ShouldBeGreaterThanOrEqualTo, time.Millisecond*20)
+
+			// stop retrying once it succeeded
+			attempts = []time.Time{}
+			So(policy.Retry(context.Background(), func() error {
+				attempts = append(attempts, time.Now())
+				return nil
+			}), ShouldBeNil)
+			So(attempts, ShouldHaveLength, 1)
+
+		})
+
 	})
 
 }
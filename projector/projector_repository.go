@@ -2,28 +2,87 @@ package projector
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/florianlenz/event-sourcing-go/event"
 	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
 	"github.com/mongodb/mongo-go-driver/mongo/options"
+	"github.com/mongodb/mongo-go-driver/x/network/command"
+	"sync"
 )
 
+// error code of mongo in case a collection exists already
+const namespaceExists = 48
+
 type IProjectorRepository interface {
 	// check if projector is out of sync
 	OutOfSyncBy(projector IProjector) (int64, error)
 	// update the last handled event on the projector
 	UpdateLastHandledEvent(projector IProjector, event event.Event) error
+	// the last event handled by the projector - nil if it didn't handle any event yet
+	LastHandledEvent(projector IProjector) (*primitive.ObjectID, error)
 	// drop projector collection
 	Drop() error
+	// reset the projector so that it starts over with the first event
+	Reset(projector IProjector) error
 }
 
 type projectorRepository struct {
 	eventCollection     *mongo.Collection
 	projectorCollection *mongo.Collection
 	eventRegistry       *event.Registry
+	lock                *sync.Mutex
+	// set once the projector collection got created
+	collectionCreated bool
 }
 
 func (r *projectorRepository) UpdateLastHandledEvent(projector IProjector, event event.Event) error {
+	return r.updateLastHandledEvent(context.Background(), projector, event)
+}
+
+func (r *projectorRepository) UpdateLastHandledEventInTransaction(tx ITransaction, projector IProjector, event event.Event) error {
+
+	mongoTransaction, k := tx.(*MongoTransaction)
+	if !k {
+		return errors.New("the projector repository only supports mongo transactions")
+	}
+
+	return r.updateLastHandledEvent(mongoTransaction.Session, projector, event)
+
+}
+
+func (r *projectorRepository) InTransaction(ctx context.Context, fn func(tx ITransaction) error) error {
+
+	// mongo < 4.4 can't create collections within a transaction
+	if err := r.createCollection(ctx); err != nil {
+		return err
+	}
+
+	client := r.projectorCollection.Database().Client()
+
+	return client.UseSession(ctx, func(session mongo.SessionContext) error {
+
+		if err := session.StartTransaction(); err != nil {
+			return err
+		}
+
+		// abort the transaction in case we failed
+		if err := fn(&MongoTransaction{Session: session}); err != nil {
+			if abortErr := session.AbortTransaction(session); abortErr != nil {
+				return fmt.Errorf("%s (failed to abort transaction: %s)", err, abortErr)
+			}
+			return err
+		}
+
+		return session.CommitTransaction(session)
+
+	})
+
+}
+
+func (r *projectorRepository) updateLastHandledEvent(ctx context.Context, projector IProjector, event event.Event) error {
 
 	projectors := r.projectorCollection
 
@@ -32,7 +91,7 @@ func (r *projectorRepository) UpdateLastHandledEvent(projector IProjector, event
 	updateOptions.SetUpsert(true)
 
 	_, err := projectors.UpdateOne(
-		context.Background(),
+		ctx,
 		bson.M{"name": projector.Name()},
 		bson.M{
 			"$set": bson.M{
@@ -46,8 +105,77 @@ func (r *projectorRepository) UpdateLastHandledEvent(projector IProjector, event
 
 }
 
+func (r *projectorRepository) LastHandledEvent(p IProjector) (*primitive.ObjectID, error) {
+
+	// fetch projector
+	result := r.projectorCollection.FindOne(context.Background(), bson.M{
+		"name": p.Name(),
+	})
+
+	fetchedProjector := &projector{}
+
+	// decode fetched projector
+	err := result.Decode(fetchedProjector)
+	switch err {
+	case nil:
+		return fetchedProjector.LastProcessedEvent, nil
+	case mongo.ErrNoDocuments:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+}
+
+// create the projector collection in case it doesn't exist yet
+func (r *projectorRepository) createCollection(ctx context.Context) error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	if r.collectionCreated {
+		return nil
+	}
+
+	err := r.projectorCollection.Database().RunCommand(ctx, bson.D{{Key: "create", Value: r.projectorCollection.Name()}}).Err()
+	if commandErr, k := err.(command.Error); k && commandErr.Code == namespaceExists {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.collectionCreated = true
+
+	return nil
+
+}
+
+// Drop the projector collection. It's created again right away - the repositories of other processes expect it to
+// exist in order to update the checkpoints within transactions.
 func (r *projectorRepository) Drop() error {
-	return r.projectorCollection.Drop(context.Background())
+
+	if err := r.projectorCollection.Drop(context.Background()); err != nil {
+		return err
+	}
+
+	// lock
+	r.lock.Lock()
+	r.collectionCreated = false
+	r.lock.Unlock()
+
+	return r.createCollection(context.Background())
+
+}
+
+func (r *projectorRepository) Reset(projector IProjector) error {
+	_, err := r.projectorCollection.DeleteOne(context.Background(), bson.M{
+		"name": projector.Name(),
+	})
+	return err
 }
 
 func (r *projectorRepository) OutOfSyncBy(p IProjector) (int64, error) {
@@ -100,5 +228,6 @@ func NewProjectorRepository(eventCollection, projectorCollection *mongo.Collecti
 		eventCollection:     eventCollection,
 		projectorCollection: projectorCollection,
 		eventRegistry:       eventRegistry,
+		lock:                &sync.Mutex{},
 	}
 }
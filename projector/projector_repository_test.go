@@ -377,6 +377,91 @@ func TestProjectorRepository(t *testing.T) {
 
 		})
 
+		Convey("reset projector", func() {
+
+			// db
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			projectorCollection := db.Collection("projectors")
+
+			// projector repository
+			projectorRepository := NewProjectorRepository(db.Collection("events"), projectorCollection, event.NewEventRegistry())
+
+			// insert test projectors
+			_, err = projectorCollection.InsertMany(context.Background(), []interface{}{
+				bson.M{"name": "user.projector"},
+				bson.M{"name": "account.projector"},
+			})
+			So(err, ShouldBeNil)
+
+			// reset the user projector
+			So(projectorRepository.Reset(&testProjector{name: "user.projector"}), ShouldBeNil)
+
+			// ensure that only the user projector got removed
+			projectorCount, err := projectorCollection.Count(context.Background(), bson.M{})
+			So(err, ShouldBeNil)
+			So(projectorCount, ShouldEqual, 1)
+
+			projectorCount, err = projectorCollection.Count(context.Background(), bson.M{"name": "user.projector"})
+			So(err, ShouldBeNil)
+			So(projectorCount, ShouldEqual, 0)
+
+		})
+
+		Convey("last handled event", func() {
+
+			// db
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			// projector repository
+			projectorRepository := NewProjectorRepository(db.Collection("events"), db.Collection("projectors"), event.NewEventRegistry())
+			userProjector := &testProjector{name: "user.projector"}
+
+			// projector never handled an event
+			lastHandledEvent, err := projectorRepository.LastHandledEvent(userProjector)
+			So(err, ShouldBeNil)
+			So(lastHandledEvent, ShouldBeNil)
+
+			eventID := primitive.NewObjectID()
+			So(projectorRepository.UpdateLastHandledEvent(userProjector, event.Event{ID: &eventID}), ShouldBeNil)
+
+			lastHandledEvent, err = projectorRepository.LastHandledEvent(userProjector)
+			So(err, ShouldBeNil)
+			So(*lastHandledEvent, ShouldEqual, eventID)
+
+		})
+
+		Convey("update the last handled event within a transaction before and after the projectors got dropped", func() {
+
+			// db without a projector collection
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			// projector repository
+			projectorRepository := NewProjectorRepository(db.Collection("events"), db.Collection("projectors"), event.NewEventRegistry())
+			userProjector := &testProjector{name: "user.projector"}
+
+			for i := 0; i < 2; i++ {
+
+				eventID := primitive.NewObjectID()
+				err := projectorRepository.InTransaction(context.Background(), func(tx ITransaction) error {
+					return projectorRepository.UpdateLastHandledEventInTransaction(tx, userProjector, event.Event{ID: &eventID})
+				})
+				So(err, ShouldBeNil)
+
+				lastHandledEvent, err := projectorRepository.LastHandledEvent(userProjector)
+				So(err, ShouldBeNil)
+				So(*lastHandledEvent, ShouldEqual, eventID)
+
+				// a replay drops the projectors
+				So(projectorRepository.Drop(), ShouldBeNil)
+
+			}
+
+		})
+
 	})
 
 }
@@ -0,0 +1,222 @@
+package projector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"regexp"
+)
+
+// name of a table - optionally qualified with the schema
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// creates the placeholder of the n-th (starting at 1) query parameter
+type SQLPlaceholder func(n int) string
+
+// placeholders used by e.g. MySQL and SQLite
+func QuestionMarkPlaceholder(n int) string {
+	return "?"
+}
+
+// placeholders used by e.g. PostgreSQL
+func DollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// projector repository that keeps the projector checkpoints in a sql table. Projectors that implement
+// ITransactionalProjector write their read model in the same transaction as the checkpoint.
+type sqlProjectorRepository struct {
+	db              *sql.DB
+	queries         sqlQueries
+	eventRepository event.IEventRepository
+	eventRegistry   *event.Registry
+}
+
+// the queries of the repository - they are built once since the table name can't be passed as query parameter
+type sqlQueries struct {
+	createTable      string
+	updateCheckpoint string
+	insertCheckpoint string
+	selectCheckpoint string
+	deleteAll        string
+	deleteCheckpoint string
+}
+
+func newSQLQueries(table string, placeholder SQLPlaceholder) sqlQueries {
+	return sqlQueries{
+		createTable:      fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name VARCHAR(255) NOT NULL PRIMARY KEY, last_processed_event CHAR(24) NOT NULL)", table),
+		updateCheckpoint: fmt.Sprintf("UPDATE %s SET last_processed_event = %s WHERE name = %s", table, placeholder(1), placeholder(2)),
+		insertCheckpoint: fmt.Sprintf("INSERT INTO %s (name, last_processed_event) VALUES (%s, %s)", table, placeholder(1), placeholder(2)),
+		selectCheckpoint: fmt.Sprintf("SELECT last_processed_event FROM %s WHERE name = %s", table, placeholder(1)),
+		deleteAll:        fmt.Sprintf("DELETE FROM %s", table),
+		deleteCheckpoint: fmt.Sprintf("DELETE FROM %s WHERE name = %s", table, placeholder(1)),
+	}
+}
+
+// interface shared by *sql.DB and *sql.Tx
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// create the checkpoint table in case it doesn't exist yet
+func (r *sqlProjectorRepository) CreateTable() error {
+	_, err := r.db.Exec(r.queries.createTable)
+	return err
+}
+
+func (r *sqlProjectorRepository) UpdateLastHandledEvent(projector IProjector, event event.Event) error {
+	return r.updateLastHandledEvent(context.Background(), r.db, projector, event)
+}
+
+func (r *sqlProjectorRepository) UpdateLastHandledEventInTransaction(tx ITransaction, projector IProjector, event event.Event) error {
+
+	sqlTransaction, k := tx.(*SQLTransaction)
+	if !k {
+		return errors.New("the projector repository only supports sql transactions")
+	}
+
+	return r.updateLastHandledEvent(sqlTransaction.ctx, sqlTransaction.Tx, projector, event)
+
+}
+
+func (r *sqlProjectorRepository) InTransaction(ctx context.Context, fn func(tx ITransaction) error) error {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// roll back the transaction in case we failed
+	if err := fn(&SQLTransaction{ctx: ctx, Tx: tx}); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%s (failed to roll back transaction: %s)", err, rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+
+}
+
+// There is no portable upsert - insert the checkpoint in case there was none to update. The checkpoint of a
+// projector is only updated by one worker at a time.
+func (r *sqlProjectorRepository) updateLastHandledEvent(ctx context.Context, executor sqlExecutor, projector IProjector, event event.Event) error {
+
+	if event.ID == nil {
+		return errors.New("can't update the last handled event with an event that has no id")
+	}
+
+	result, err := executor.ExecContext(
+		ctx,
+		r.queries.updateCheckpoint,
+		event.ID.Hex(),
+		projector.Name(),
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		return nil
+	}
+
+	_, err = executor.ExecContext(
+		ctx,
+		r.queries.insertCheckpoint,
+		projector.Name(),
+		event.ID.Hex(),
+	)
+	return err
+
+}
+
+func (r *sqlProjectorRepository) LastHandledEvent(projector IProjector) (*primitive.ObjectID, error) {
+
+	var lastHandledEvent string
+	err := r.db.QueryRow(
+		r.queries.selectCheckpoint,
+		projector.Name(),
+	).Scan(&lastHandledEvent)
+
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	id, err := primitive.ObjectIDFromHex(lastHandledEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+
+}
+
+func (r *sqlProjectorRepository) Drop() error {
+	_, err := r.db.Exec(r.queries.deleteAll)
+	return err
+}
+
+func (r *sqlProjectorRepository) Reset(projector IProjector) error {
+	_, err := r.db.Exec(r.queries.deleteCheckpoint, projector.Name())
+	return err
+}
+
+func (r *sqlProjectorRepository) OutOfSyncBy(p IProjector) (int64, error) {
+
+	// event names that the projector subscribed to
+	eventNames := []string{}
+	for _, e := range p.InterestedInEvents() {
+		eventName, err := r.eventRegistry.GetEventName(e)
+		if err != nil {
+			return 0, err
+		}
+		eventNames = append(eventNames, eventName)
+	}
+
+	// a projector without events can't be out of sync - an empty name filter would match all events
+	if len(eventNames) == 0 {
+		return 0, nil
+	}
+
+	query := event.Query{
+		Names: eventNames,
+	}
+
+	lastHandledEvent, err := r.LastHandledEvent(p)
+	if err != nil {
+		return 0, err
+	}
+	query.After = lastHandledEvent
+
+	return r.eventRepository.Count(query)
+
+}
+
+// Create a projector repository that keeps the projector checkpoints in the given sql table (see CreateTable). The
+// event repository is used to figure out if a projector is out of sync. Pass the repository to NewEventSourcing with
+// WithProjectorRepository.
+func NewSQLProjectorRepository(db *sql.DB, table string, placeholder SQLPlaceholder, eventRepository event.IEventRepository, eventRegistry *event.Registry) (*sqlProjectorRepository, error) {
+
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name '%s'", table)
+	}
+
+	return &sqlProjectorRepository{
+		db:              db,
+		queries:         newSQLQueries(table, placeholder),
+		eventRepository: eventRepository,
+		eventRegistry:   eventRegistry,
+	}, nil
+
+}
@@ -0,0 +1,325 @@
+package projector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// in memory sql database that understands the queries of the sql projector repository
+type testSQLDatabase struct {
+	lock        sync.Mutex
+	checkpoints map[string]string
+	queries     []string
+}
+
+func (d *testSQLDatabase) Connect(ctx context.Context) (driver.Conn, error) {
+	return &testSQLConn{database: d}, nil
+}
+
+func (d *testSQLDatabase) Driver() driver.Driver {
+	return nil
+}
+
+type testSQLConn struct {
+	database *testSQLDatabase
+	// checkpoints written by the running transaction
+	staged map[string]string
+}
+
+func (c *testSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &testSQLStmt{conn: c, query: query}, nil
+}
+
+func (c *testSQLConn) Close() error {
+	return nil
+}
+
+func (c *testSQLConn) Begin() (driver.Tx, error) {
+
+	c.database.lock.Lock()
+	defer c.database.lock.Unlock()
+
+	c.staged = map[string]string{}
+	for name, checkpoint := range c.database.checkpoints {
+		c.staged[name] = checkpoint
+	}
+
+	return &testSQLTx{conn: c}, nil
+
+}
+
+// checkpoints the queries of the connection work on
+func (c *testSQLConn) checkpoints() map[string]string {
+	if c.staged != nil {
+		return c.staged
+	}
+	return c.database.checkpoints
+}
+
+type testSQLTx struct {
+	conn *testSQLConn
+}
+
+func (t *testSQLTx) Commit() error {
+
+	t.conn.database.lock.Lock()
+	defer t.conn.database.lock.Unlock()
+
+	t.conn.database.checkpoints = t.conn.staged
+	t.conn.staged = nil
+
+	return nil
+
+}
+
+func (t *testSQLTx) Rollback() error {
+	t.conn.staged = nil
+	return nil
+}
+
+type testSQLStmt struct {
+	conn  *testSQLConn
+	query string
+}
+
+func (s *testSQLStmt) Close() error {
+	return nil
+}
+
+func (s *testSQLStmt) NumInput() int {
+	return -1
+}
+
+func (s *testSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+
+	s.conn.database.lock.Lock()
+	defer s.conn.database.lock.Unlock()
+
+	s.conn.database.queries = append(s.conn.database.queries, s.query)
+	checkpoints := s.conn.checkpoints()
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "UPDATE"):
+		name := args[1].(string)
+		if _, exists := checkpoints[name]; !exists {
+			return driver.RowsAffected(0), nil
+		}
+		checkpoints[name] = args[0].(string)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "INSERT"):
+		checkpoints[args[0].(string)] = args[1].(string)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "DELETE") && len(args) == 1:
+		delete(checkpoints, args[0].(string))
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "DELETE"):
+		for name := range checkpoints {
+			delete(checkpoints, name)
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	return nil, errors.New("unexpected query: " + s.query)
+
+}
+
+func (s *testSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+
+	s.conn.database.lock.Lock()
+	defer s.conn.database.lock.Unlock()
+
+	s.conn.database.queries = append(s.conn.database.queries, s.query)
+
+	checkpoint, exists := s.conn.checkpoints()[args[0].(string)]
+	if !exists {
+		return &testSQLRows{}, nil
+	}
+
+	return &testSQLRows{values: []string{checkpoint}}, nil
+
+}
+
+type testSQLRows struct {
+	values []string
+}
+
+func (r *testSQLRows) Columns() []string {
+	return []string{"last_processed_event"}
+}
+
+func (r *testSQLRows) Close() error {
+	return nil
+}
+
+func (r *testSQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
+
+type testSQLContextKey struct{}
+
+func TestSQLProjectorRepository(t *testing.T) {
+
+	Convey("sql projector repository", t, func() {
+
+		database := &testSQLDatabase{checkpoints: map[string]string{}}
+		db := sql.OpenDB(database)
+		db.SetMaxOpenConns(1)
+
+		projector := &testProjector{name: "user.projector"}
+
+		Convey("only table names (optionally qualified with the schema) are accepted", func() {
+
+			for _, table := range []string{"projectors", "public.projectors", "_projectors2"} {
+				_, err := NewSQLProjectorRepository(db, table, QuestionMarkPlaceholder, nil, event.NewEventRegistry())
+				So(err, ShouldBeNil)
+			}
+
+			for _, table := range []string{"", "projectors; DROP TABLE users", "a.b.c", "1projectors", "projectors "} {
+				_, err := NewSQLProjectorRepository(db, table, QuestionMarkPlaceholder, nil, event.NewEventRegistry())
+				So(err, ShouldBeError, "invalid table name '"+table+"'")
+			}
+
+		})
+
+		Convey("the queries use the placeholders of the database", func() {
+
+			repository, err := NewSQLProjectorRepository(db, "public.projectors", DollarPlaceholder, nil, event.NewEventRegistry())
+			So(err, ShouldBeNil)
+
+			id := primitive.NewObjectID()
+			So(repository.UpdateLastHandledEvent(projector, event.Event{ID: &id}), ShouldBeNil)
+
+			So(database.queries, ShouldResemble, []string{
+				"UPDATE public.projectors SET last_processed_event = $1 WHERE name = $2",
+				"INSERT INTO public.projectors (name, last_processed_event) VALUES ($1, $2)",
+			})
+
+		})
+
+		Convey("checkpoints", func() {
+
+			repository, err := NewSQLProjectorRepository(db, "projectors", QuestionMarkPlaceholder, nil, event.NewEventRegistry())
+			So(err, ShouldBeNil)
+			So(repository.CreateTable(), ShouldBeNil)
+
+			Convey("a projector that didn't handle any event yet has no checkpoint", func() {
+
+				lastHandledEvent, err := repository.LastHandledEvent(projector)
+				So(err, ShouldBeNil)
+				So(lastHandledEvent, ShouldBeNil)
+
+			})
+
+			Convey("the checkpoint is inserted and updated afterwards", func() {
+
+				first := primitive.NewObjectID()
+				So(repository.UpdateLastHandledEvent(projector, event.Event{ID: &first}), ShouldBeNil)
+
+				second := primitive.NewObjectID()
+				So(repository.UpdateLastHandledEvent(projector, event.Event{ID: &second}), ShouldBeNil)
+
+				lastHandledEvent, err := repository.LastHandledEvent(projector)
+				So(err, ShouldBeNil)
+				So(*lastHandledEvent, ShouldEqual, second)
+				So(database.checkpoints, ShouldHaveLength, 1)
+
+			})
+
+			Convey("events without an id can't be checkpoints", func() {
+				So(repository.UpdateLastHandledEvent(projector, event.Event{}), ShouldBeError, "can't update the last handled event with an event that has no id")
+			})
+
+			Convey("reset and drop delete the checkpoints", func() {
+
+				other := &testProjector{name: "other.projector"}
+				id := primitive.NewObjectID()
+				So(repository.UpdateLastHandledEvent(projector, event.Event{ID: &id}), ShouldBeNil)
+				So(repository.UpdateLastHandledEvent(other, event.Event{ID: &id}), ShouldBeNil)
+
+				So(repository.Reset(projector), ShouldBeNil)
+				So(database.checkpoints, ShouldResemble, map[string]string{"other.projector": id.Hex()})
+
+				So(repository.Drop(), ShouldBeNil)
+				So(database.checkpoints, ShouldBeEmpty)
+
+			})
+
+		})
+
+		Convey("transactions", func() {
+
+			repository, err := NewSQLProjectorRepository(db, "projectors", QuestionMarkPlaceholder, nil, event.NewEventRegistry())
+			So(err, ShouldBeNil)
+
+			id := primitive.NewObjectID()
+
+			Convey("the checkpoint is committed together with the transaction", func() {
+
+				ctx := context.WithValue(context.Background(), testSQLContextKey{}, "value")
+				err := repository.InTransaction(ctx, func(tx ITransaction) error {
+					So(tx.Context().Value(testSQLContextKey{}), ShouldEqual, "value")
+					return repository.UpdateLastHandledEventInTransaction(tx, projector, event.Event{ID: &id})
+				})
+				So(err, ShouldBeNil)
+
+				lastHandledEvent, err := repository.LastHandledEvent(projector)
+				So(err, ShouldBeNil)
+				So(*lastHandledEvent, ShouldEqual, id)
+
+			})
+
+			Convey("the checkpoint is rolled back in case the transaction failed", func() {
+
+				err := repository.InTransaction(context.Background(), func(tx ITransaction) error {
+					So(repository.UpdateLastHandledEventInTransaction(tx, projector, event.Event{ID: &id}), ShouldBeNil)
+					return errors.New("projector failed")
+				})
+				So(err, ShouldBeError, "projector failed")
+
+				lastHandledEvent, err := repository.LastHandledEvent(projector)
+				So(err, ShouldBeNil)
+				So(lastHandledEvent, ShouldBeNil)
+
+			})
+
+			Convey("a cancelled context doesn't start a transaction", func() {
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				called := false
+				err := repository.InTransaction(ctx, func(tx ITransaction) error {
+					called = true
+					return nil
+				})
+				So(err, ShouldEqual, context.Canceled)
+				So(called, ShouldBeFalse)
+
+			})
+
+			Convey("only sql transactions are supported", func() {
+				err := repository.UpdateLastHandledEventInTransaction(&MongoTransaction{}, projector, event.Event{ID: &id})
+				So(err, ShouldBeError, "the projector repository only supports sql transactions")
+			})
+
+		})
+
+	})
+
+}
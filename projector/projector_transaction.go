@@ -0,0 +1,54 @@
+package projector
+
+import (
+	"context"
+	"database/sql"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// transaction of the store the read models and the projector checkpoints are written to
+type ITransaction interface {
+	// context of the transaction - operations must use it in order to be part of the transaction
+	Context() context.Context
+}
+
+// Projectors that write their read model within the transaction of the projector repository. The read model and the
+// checkpoint of the projector are committed together. The context of the transaction carries the values of the
+// commit context. Handle is used in case the projector repository doesn't support transactions (e.g. the in memory
+// repository).
+type ITransactionalProjector interface {
+	IProjector
+	// handle the event within the transaction
+	HandleInTransaction(tx ITransaction, event event.IESEvent) error
+}
+
+// projector repositories that are able to update the checkpoint of a projector within a transaction
+type ITransactionalProjectorRepository interface {
+	IProjectorRepository
+	// run fn within a transaction. The transaction is committed if fn succeeds and aborted otherwise. The context of
+	// the transaction is derived from the given context.
+	InTransaction(ctx context.Context, fn func(tx ITransaction) error) error
+	// update the last handled event on the projector within the transaction
+	UpdateLastHandledEventInTransaction(tx ITransaction, projector IProjector, event event.Event) error
+}
+
+// Transaction of a mongo session. Pass the session to the collection operations of your projector, e.g.
+// collection.InsertOne(tx.Session, document).
+type MongoTransaction struct {
+	Session mongo.SessionContext
+}
+
+func (t *MongoTransaction) Context() context.Context {
+	return t.Session
+}
+
+// transaction of a sql database - run the queries of your projector on Tx
+type SQLTransaction struct {
+	ctx context.Context
+	Tx  *sql.Tx
+}
+
+func (t *SQLTransaction) Context() context.Context {
+	return t.ctx
+}
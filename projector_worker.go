@@ -0,0 +1,524 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/projector"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+type projectorJob struct {
+	ctx            context.Context
+	persistedEvent event.Event
+	esEvent        event.IESEvent
+	done           *sync.WaitGroup
+	report         *Report
+	// apply the event even if the projector handled it before (e.g. when retrying a dead letter)
+	reprocess bool
+}
+
+// a job that got handed over to a partition of a partitioned projector
+type partitionJob struct {
+	projectorJob
+	// receives the result of handling the event
+	handled chan error
+	// the projector handled the event before - the checkpoint must not move back
+	alreadyHandled bool
+}
+
+// error returned by a partition of a partitioned projector when it failed to handle an event
+type handleFailure struct {
+	err error
+}
+
+func (f *handleFailure) Error() string {
+	return f.err.Error()
+}
+
+// Sent by a partition of a transactional projector once it handled the event within a transaction. The transaction
+// stays open till the committer completed it, so that the checkpoint moves within the transaction in the order the
+// events got processed.
+type partitionTransaction struct {
+	// completes the transaction (e.g. moves the checkpoint) - the transaction is aborted in case it fails
+	complete chan func(tx projector.ITransaction) error
+	// receives the result of committing the transaction
+	committed chan error
+}
+
+func (t *partitionTransaction) Error() string {
+	return "the transaction of the event hasn't been committed yet"
+}
+
+// applies the events to one projector in the order they got processed.
+// Each projector has its own worker so that a slow projector doesn't block the others.
+type projectorWorker struct {
+	projector projector.IProjector
+	jobs      chan projectorJob
+	queueSize int
+	batchSize int
+	// how the projector deals with errors
+	policy projector.ErrorPolicy
+	// set once the projector halted
+	halt int32
+}
+
+func (w *projectorWorker) run(p *Processor) {
+
+	// partitioned projectors handle events of different partitions concurrently
+	if partitionedProjector, k := w.projector.(projector.IPartitionedProjector); k && partitionedProjector.Partitions() > 1 {
+		w.runPartitioned(p, partitionedProjector.Partitions())
+		return
+	}
+
+	// batch projectors get all queued events at once
+	if batchProjector, k := w.projector.(projector.IBatchProjector); k {
+		w.runBatches(p, batchProjector)
+		return
+	}
+
+	for job := range w.jobs {
+		p.projected(w.projector, job, w.apply(p, job))
+	}
+
+}
+
+// apply the event to the projector and move its checkpoint
+func (w *projectorWorker) apply(p *Processor, job projectorJob) error {
+
+	if err := w.halted(); err != nil {
+		return err
+	}
+
+	alreadyHandled, err := p.alreadyHandled(w.projector, job.persistedEvent)
+	if err != nil {
+		return err
+	}
+
+	// skip the event unless we are forced to reprocess it
+	if alreadyHandled && !p.config.forceReprocessing && !job.reprocess {
+		return nil
+	}
+
+	if !alreadyHandled {
+		if err := p.inSync(w.projector, job.persistedEvent, 0); err != nil {
+			return err
+		}
+	}
+
+	// handle event and update the last handled event on the projector - the checkpoint must not move back
+	err = w.policy.Retry(job.ctx, func() error {
+		return handleEvent(job.ctx, p.projectorRepository, w.projector, job.persistedEvent, job.esEvent, !alreadyHandled)
+	})
+	if err != nil {
+		_, err = w.failed(p, job.persistedEvent, !alreadyHandled, err)
+	}
+
+	return err
+
+}
+
+// hand the event over to the projector - projectors that implement projector.IContextProjector get the context
+func project(ctx context.Context, p projector.IProjector, esEvent event.IESEvent) error {
+
+	if contextProjector, k := p.(projector.IContextProjector); k {
+		return contextProjector.HandleContext(ctx, esEvent)
+	}
+
+	return p.Handle(esEvent)
+
+}
+
+// a halted projector doesn't handle any further events
+func (w *projectorWorker) halted() error {
+
+	if atomic.LoadInt32(&w.halt) == 1 {
+		return fmt.Errorf("projector '%s' is halted", w.projector.Name())
+	}
+
+	return nil
+
+}
+
+// Deal with an event the projector finally failed to handle according to its error policy. The event is recorded as
+// dead letter. Parking the event moves the
+// checkpoint past it in case updateCheckpoint is true.
+func (w *projectorWorker) failed(p *Processor, persistedEvent event.Event, updateCheckpoint bool, err error) (bool, error) {
+
+	p.deadLetter(persistedEvent, w.projector.Name(), "", err, w.policy.Retries+1)
+
+	switch w.policy.OnFailure {
+	case projector.OnFailurePark:
+		if updateCheckpoint {
+			if checkpointErr := p.projectorRepository.UpdateLastHandledEvent(w.projector, persistedEvent); checkpointErr != nil {
+				return false, fmt.Errorf("%s (failed to park event: %s)", err, checkpointErr)
+			}
+		}
+		return true, fmt.Errorf("projector '%s' parked event with name '%s': %s", w.projector.Name(), persistedEvent.Name, err)
+	case projector.OnFailureHalt:
+		atomic.StoreInt32(&w.halt, 1)
+		return false, fmt.Errorf("projector '%s' halted on event with name '%s': %s", w.projector.Name(), persistedEvent.Name, err)
+	default:
+		return false, err
+	}
+
+}
+
+// Apply the event to the projector and move its checkpoint if requested. The read model and the checkpoint are written
+// in one transaction in case both the projector and the projector repository support transactions. A panic of the
+// projector is returned as PanicError.
+func handleEvent(ctx context.Context, repository projector.IProjectorRepository, p projector.IProjector, persistedEvent event.Event, esEvent event.IESEvent, updateCheckpoint bool) error {
+
+	transactionalProjector, isTransactionalProjector := p.(projector.ITransactionalProjector)
+	transactionalRepository, isTransactionalRepository := repository.(projector.ITransactionalProjectorRepository)
+
+	if isTransactionalProjector && isTransactionalRepository {
+		return transactionalRepository.InTransaction(ctx, func(tx projector.ITransaction) error {
+
+			err := recovered(func() error {
+				return transactionalProjector.HandleInTransaction(tx, esEvent)
+			})
+			if err != nil {
+				return err
+			}
+
+			if !updateCheckpoint {
+				return nil
+			}
+
+			return transactionalRepository.UpdateLastHandledEventInTransaction(tx, p, persistedEvent)
+
+		})
+	}
+
+	err := recovered(func() error {
+		return project(ctx, p, esEvent)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !updateCheckpoint {
+		return nil
+	}
+
+	return repository.UpdateLastHandledEvent(p, persistedEvent)
+
+}
+
+func (w *projectorWorker) runBatches(p *Processor, batchProjector projector.IBatchProjector) {
+
+	for job := range w.jobs {
+
+		// collect the events that are already queued
+		batch := []projectorJob{job}
+	collect:
+		for len(batch) < w.batchSize {
+			select {
+			case next, open := <-w.jobs:
+				if !open {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+
+		if err := w.halted(); err != nil {
+			for _, job := range batch {
+				p.projected(w.projector, job, err)
+			}
+			continue
+		}
+
+		// skip the events the projector already handled unless we are forced to reprocess them
+		jobs := []projectorJob{}
+		esEvents := []event.IESEvent{}
+		newEvents := 0
+		var lastEvent event.Event
+		var err error
+		for i, job := range batch {
+
+			alreadyHandled, handledErr := p.alreadyHandled(w.projector, job.persistedEvent)
+			if handledErr != nil {
+				// none of the remaining events get handled
+				err = handledErr
+				jobs = append(jobs, batch[i:]...)
+				break
+			}
+
+			if alreadyHandled && !p.config.forceReprocessing && !job.reprocess {
+				p.projected(w.projector, job, nil)
+				continue
+			}
+
+			jobs = append(jobs, job)
+			esEvents = append(esEvents, job.esEvent)
+			if !alreadyHandled {
+				newEvents++
+				lastEvent = job.persistedEvent
+			}
+
+		}
+
+		// the projector is out of sync by the new events of the batch. The checkpoint is updated once for all events.
+		if err == nil && newEvents > 0 {
+			err = p.inSync(w.projector, lastEvent, int64(newEvents-1))
+		}
+		if err == nil && len(esEvents) > 0 {
+			err = w.policy.Retry(p.ctx, func() error {
+				return recovered(func() error {
+					return batchProjector.HandleBatch(esEvents)
+				})
+			})
+			if err != nil {
+				// a parked batch moves the checkpoint past all of its events
+				failedEvent := jobs[len(jobs)-1].persistedEvent
+				if newEvents > 0 {
+					failedEvent = lastEvent
+				}
+				_, err = w.failed(p, failedEvent, newEvents > 0, err)
+				for _, job := range jobs {
+					p.projected(w.projector, job, err)
+				}
+				continue
+			}
+		}
+		if err == nil && newEvents > 0 {
+			err = p.projectorRepository.UpdateLastHandledEvent(w.projector, lastEvent)
+		}
+
+		for _, job := range jobs {
+			p.projected(w.projector, job, err)
+		}
+
+	}
+
+}
+
+// Dispatches the events by their partition key to the partitions. Events of the same partition are handled in order.
+// The checkpoint of the projector is moved in the order the events got processed so that it never skips an event
+// that is still handled by another partition.
+func (w *projectorWorker) runPartitioned(p *Processor, partitions int) {
+
+	// amount of events that got dispatched but are not committed yet
+	var inFlight int64
+
+	// start the partitions
+	partitionJobs := make([]chan partitionJob, partitions)
+	for i := range partitionJobs {
+		partitionJobs[i] = make(chan partitionJob, w.queueSize)
+		go func(jobs chan partitionJob) {
+			for job := range jobs {
+				w.handlePartitionJob(p, job)
+			}
+		}(partitionJobs[i])
+	}
+
+	// commit the events in the order they got dispatched
+	committed := make(chan partitionJob, w.queueSize*partitions)
+	committerDone := make(chan struct{})
+	go func() {
+
+		defer close(committerDone)
+
+		// once an event couldn't be applied the checkpoint must no longer move - the projector is out of sync
+		failed := false
+
+		for job := range committed {
+
+			err := <-job.handled
+
+			// transactional projectors move the checkpoint within the transaction of the partition
+			committedInTransaction := false
+			if transaction, k := err.(*partitionTransaction); k {
+				err = w.commitTransaction(p, transaction, job, failed)
+				committedInTransaction = err == nil
+				if err != nil && !failed {
+					err = &handleFailure{err: err}
+				}
+			}
+
+			// the error policy only applies as long as the checkpoint moves
+			parked := false
+			if failure, k := err.(*handleFailure); k {
+				err = failure.err
+				if !failed {
+					parked, err = w.failed(p, job.persistedEvent, !job.alreadyHandled, err)
+				}
+			}
+
+			switch {
+			case parked:
+			case err != nil:
+			case committedInTransaction:
+			case job.alreadyHandled:
+			case failed:
+				err = fmt.Errorf("projector '%s' is out of sync - tried to apply event with name '%s'", w.projector.Name(), job.persistedEvent.Name)
+			default:
+				err = p.projectorRepository.UpdateLastHandledEvent(w.projector, job.persistedEvent)
+			}
+			failed = failed || (err != nil && !parked)
+
+			atomic.AddInt64(&inFlight, -1)
+			p.projected(w.projector, job.projectorJob, err)
+
+		}
+
+	}()
+
+	for job := range w.jobs {
+
+		// the in flight events must be loaded before the checkpoint. A committed event is always removed from the
+		// in flight events after the checkpoint got moved.
+		dispatched := atomic.LoadInt64(&inFlight)
+		atomic.AddInt64(&inFlight, 1)
+
+		alreadyHandled, err := p.alreadyHandled(w.projector, job.persistedEvent)
+
+		pJob := partitionJob{
+			projectorJob:   job,
+			handled:        make(chan error, 1),
+			alreadyHandled: alreadyHandled,
+		}
+		committed <- pJob
+
+		if err == nil {
+			err = w.halted()
+		}
+		if err != nil {
+			pJob.handled <- err
+			continue
+		}
+
+		// skip the event unless we are forced to reprocess it
+		if alreadyHandled && !p.config.forceReprocessing && !job.reprocess {
+			pJob.handled <- nil
+			continue
+		}
+
+		if !alreadyHandled {
+			if err := p.inSync(w.projector, job.persistedEvent, dispatched); err != nil {
+				pJob.handled <- err
+				continue
+			}
+		}
+
+		partitionJobs[partition(projector.PartitionKey(w.projector, job.esEvent), partitions)] <- pJob
+
+	}
+
+	// shut down the partitions and the committer
+	for _, jobs := range partitionJobs {
+		close(jobs)
+	}
+	close(committed)
+	<-committerDone
+
+}
+
+// Handle the event of a partition and send the result to the committer. Transactional projectors handle the event
+// within a transaction that is handed over to the committer.
+func (w *projectorWorker) handlePartitionJob(p *Processor, job partitionJob) {
+
+	transactionalProjector, isTransactionalProjector := w.projector.(projector.ITransactionalProjector)
+	transactionalRepository, isTransactionalRepository := p.projectorRepository.(projector.ITransactionalProjectorRepository)
+
+	if !isTransactionalProjector || !isTransactionalRepository {
+		err := w.policy.Retry(job.ctx, func() error {
+			return recovered(func() error {
+				return project(job.ctx, w.projector, job.esEvent)
+			})
+		})
+		if err != nil {
+			job.handled <- &handleFailure{err: err}
+			return
+		}
+		job.handled <- nil
+		return
+	}
+
+	// only handling the event is retried - once the transaction got handed over the committer decides
+	var transaction *partitionTransaction
+	err := w.policy.Retry(job.ctx, func() error {
+
+		err := transactionalRepository.InTransaction(job.ctx, func(tx projector.ITransaction) error {
+
+			err := recovered(func() error {
+				return transactionalProjector.HandleInTransaction(tx, job.esEvent)
+			})
+			if err != nil {
+				return err
+			}
+
+			transaction = &partitionTransaction{
+				complete:  make(chan func(tx projector.ITransaction) error, 1),
+				committed: make(chan error, 1),
+			}
+			job.handled <- transaction
+
+			return (<-transaction.complete)(tx)
+
+		})
+
+		if transaction != nil {
+			transaction.committed <- err
+			return nil
+		}
+
+		return err
+
+	})
+	if err != nil {
+		job.handled <- &handleFailure{err: err}
+	}
+
+}
+
+// Complete the transaction of an event that got handled by a partition. The checkpoint is moved within the
+// transaction unless the projector handled the event before. In case an event before failed the transaction is
+// aborted since the projector is out of sync.
+func (w *projectorWorker) commitTransaction(p *Processor, transaction *partitionTransaction, job partitionJob, failed bool) error {
+
+	complete := func(tx projector.ITransaction) error {
+		return nil
+	}
+
+	switch {
+	case failed:
+		outOfSync := fmt.Errorf("projector '%s' is out of sync - tried to apply event with name '%s'", w.projector.Name(), job.persistedEvent.Name)
+		complete = func(tx projector.ITransaction) error {
+			return outOfSync
+		}
+	case !job.alreadyHandled:
+		transactionalRepository := p.projectorRepository.(projector.ITransactionalProjectorRepository)
+		complete = func(tx projector.ITransaction) error {
+			return transactionalRepository.UpdateLastHandledEventInTransaction(tx, w.projector, job.persistedEvent)
+		}
+	}
+
+	transaction.complete <- complete
+
+	return <-transaction.committed
+
+}
+
+// the partition the given key belongs to
+func partition(key string, partitions int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(partitions))
+}
+
+func newProjectorWorker(projector projector.IProjector, policy projector.ErrorPolicy, queueSize int, batchSize int) *projectorWorker {
+	return &projectorWorker{
+		projector: projector,
+		jobs:      make(chan projectorJob, queueSize),
+		queueSize: queueSize,
+		batchSize: batchSize,
+		policy:    policy,
+	}
+}
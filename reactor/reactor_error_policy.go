@@ -0,0 +1,40 @@
+package reactor
+
+import (
+	"context"
+	"github.com/florianlenz/event-sourcing-go/internal/retry"
+	"time"
+)
+
+// defines how the processor deals with errors returned by a reactor
+type ErrorPolicy struct {
+	// amount of retries before the failure is final (the event is recorded as dead letter then)
+	Retries int
+	// time to wait before the first retry - doubled with every further retry
+	Backoff time.Duration
+	// time a single attempt may take (no timeout if 0). Reactors get a context with the timeout and must return once
+	// it's done - the next attempt and the next event wait till the reactor returned. Only reactors that expect a
+	// context can be registered with a timeout.
+	Timeout time.Duration
+}
+
+// retry reacting on the event the given amount of times with exponential backoff (starting with the given backoff)
+func WithRetries(retries int, backoff time.Duration) RegisterOption {
+	return func(registration *registration) {
+		registration.errorPolicy.Retries = retries
+		registration.errorPolicy.Backoff = backoff
+	}
+}
+
+// give up on an attempt once it took longer than the timeout
+func WithTimeout(timeout time.Duration) RegisterOption {
+	return func(registration *registration) {
+		registration.errorPolicy.Timeout = timeout
+	}
+}
+
+// Run fn and retry it according to the policy till it succeeds. Stops waiting for the next attempt once the context is
+// done. The error of the last attempt is returned.
+func (p ErrorPolicy) Retry(ctx context.Context, fn func() error) error {
+	return retry.Do(ctx, p.Retries, p.Backoff, fn)
+}
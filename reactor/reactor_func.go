@@ -0,0 +1,66 @@
+package reactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"reflect"
+)
+
+// Register a function that reacts on the events of type E under the given name. The name identifies the checkpoint
+// of the reactor, so it must not change. The options are the same as for Register.
+func On[E event.IESEvent](registry *Registry, name string, handle func(ctx context.Context, e E) error, options ...RegisterOption) error {
+
+	eventType := reflect.TypeOf((*E)(nil)).Elem()
+	if eventType.Kind() == reflect.Interface {
+		return fmt.Errorf("reactor '%s' must react on a concrete event type", name)
+	}
+	if eventType.Kind() == reflect.Ptr {
+		eventType = eventType.Elem()
+	}
+
+	return registry.registerFunc(name, eventType, func(ctx context.Context, e event.IESEvent) ([]event.IESEvent, error) {
+
+		typedEvent, k := e.(E)
+		if !k {
+			return nil, fmt.Errorf("reactor '%s' expects '%s' but got '%T'", name, eventType.Name(), e)
+		}
+
+		return nil, handle(ctx, typedEvent)
+
+	}, options...)
+
+}
+
+// register a function that reacts on the given type of event
+func (r *Registry) registerFunc(name string, eventType reflect.Type, react followUpReactor, options ...RegisterOption) error {
+
+	if name == "" {
+		return errors.New("the name of the reactor must not be empty")
+	}
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	// the name identifies the checkpoint of the reactor
+	for _, handlers := range r.reactors {
+		for _, handler := range handlers {
+			if handler.name == name {
+				return fmt.Errorf("reactor with name '%s' has already been registered", name)
+			}
+		}
+	}
+
+	r.reactors[eventType] = append(r.reactors[eventType], handler{
+		name:  name,
+		react: react,
+	})
+	r.registrations[name] = newRegistration(options...)
+
+	return nil
+
+}
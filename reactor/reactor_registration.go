@@ -0,0 +1,58 @@
+package reactor
+
+// defines how the processor executes a reactor
+type ExecutionPolicy struct {
+	// maximum amount of events the reactor reacts on at the same time
+	Concurrency int
+	// events of the same stream (see event.IStreamEvent) are reacted on in the order they got committed - only relevant
+	// if the concurrency is greater than one
+	StreamOrdering bool
+}
+
+// the policies a reactor got registered with
+type registration struct {
+	errorPolicy     ErrorPolicy
+	executionPolicy ExecutionPolicy
+}
+
+type RegisterOption func(registration *registration)
+
+// React on up to the given amount of events at the same time (defaults to 1). The checkpoint of the reactor still
+// moves in the order the events got committed.
+func WithConcurrency(events int) RegisterOption {
+	return func(registration *registration) {
+		registration.executionPolicy.Concurrency = events
+	}
+}
+
+// React on events of the same stream in the order they got committed. Events of different streams are reacted on
+// concurrently (see WithConcurrency). Events that don't implement event.IStreamEvent belong to the same stream.
+func WithStreamOrdering() RegisterOption {
+	return func(registration *registration) {
+		registration.executionPolicy.StreamOrdering = true
+	}
+}
+
+func newRegistration(options ...RegisterOption) registration {
+
+	registration := registration{
+		executionPolicy: ExecutionPolicy{
+			Concurrency: 1,
+		},
+	}
+
+	for _, option := range options {
+		option(&registration)
+	}
+
+	if registration.errorPolicy.Retries < 0 {
+		registration.errorPolicy.Retries = 0
+	}
+
+	if registration.executionPolicy.Concurrency < 1 {
+		registration.executionPolicy.Concurrency = 1
+	}
+
+	return registration
+
+}
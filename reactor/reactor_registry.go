@@ -1,22 +1,73 @@
 package reactor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/internal/dispatch"
 	"reflect"
+	"sort"
 	"sync"
 )
 
-type reactor = func(event event.IESEvent)
+type reactor = func(event event.IESEvent) error
+
+type contextReactor = func(ctx context.Context, event event.IESEvent) error
+
+type outboxReactor = func(event event.IESEvent) []Message
+
+type followUpReactor = func(ctx context.Context, event event.IESEvent) ([]event.IESEvent, error)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+var messagesType = reflect.TypeOf([]Message{})
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var eventsType = reflect.TypeOf([]event.IESEvent{})
+
+// A message a reactor wants to send to an external system. Reactors with an 'Outbox(event) []reactor.Message' method
+// don't send messages themselves - the messages are stored in the outbox together with the checkpoint of the reactor
+// and delivered by the outbox relay.
+type Message struct {
+	// where the message should go to (e.g. the topic of a message broker)
+	Topic   string
+	Payload map[string]interface{}
+}
+
+// Reactors can implement this interface to choose their name. The name identifies the checkpoint of the reactor, so it
+// must not change. The name of the reactor type is used otherwise.
+type INamedReactor interface {
+	Name() string
+}
+
+// a method of a reactor (or a function) that reacts on one type of event
+type handler struct {
+	// name of the reactor
+	name    string
+	reactor reflect.Value
+	// name of the method
+	method string
+	// set for reactors that got registered as function
+	react followUpReactor
+}
 
 type Registry struct {
-	lock     *sync.Mutex
-	reactors map[reflect.Type][]reflect.Value
+	lock *sync.Mutex
+	// the handlers by the type of event they react on
+	reactors map[reflect.Type][]handler
+	// error and execution policies by reactor name
+	registrations map[string]registration
 }
 
-// Register a new reactor
-func (r *Registry) Register(reactor interface{}) error {
+// Register a new reactor. A reactor has either a 'Handle(event)', a 'HandleContext(ctx, event)' or an
+// 'Outbox(event) []Message' method. Handle and HandleContext may return follow up events ([]event.IESEvent) that are
+// committed on behalf of the reactor and an error. The options define how the processor deals with the errors.
+// Reactors that react on multiple events define one method per event instead - every method whose name starts with
+// 'On' (e.g. 'OnUserCreated(UserCreated) error') is called with the event it expects. It can take a context as first
+// parameter and return the same as Handle.
+func (r *Registry) Register(reactor interface{}, options ...RegisterOption) error {
 
 	// reactor type
 	reactorValue := reflect.ValueOf(reactor)
@@ -39,46 +90,168 @@ func (r *Registry) Register(reactor interface{}) error {
 	}()
 
 	// ensure that reactor hasn't been added
-	for _, reactors := range r.reactors {
-		for _, reactor := range reactors {
-			if reactor.Type() == reactorType {
+	for _, handlers := range r.reactors {
+		for _, handler := range handlers {
+			if handler.reactor.IsValid() && handler.reactor.Type() == reactorType {
 				return fmt.Errorf("reactor '%s' has already been registered", reactorTypeElem.Name())
 			}
+			// the name identifies the checkpoint of the reactor
+			if handler.name == reactorName(reactorValue) {
+				return fmt.Errorf("reactor with name '%s' has already been registered", reactorName(reactorValue))
+			}
 		}
 	}
 
-	// get handle method
-	handleMethod, exists := reactorType.MethodByName("Handle")
+	// get handle method - the context is passed in as first parameter in case the reactor wants it
+	handleMethod, exists := reactorType.MethodByName("HandleContext")
+	if !exists {
+		handleMethod, exists = reactorType.MethodByName("Handle")
+	}
+	if !exists {
+		handleMethod, exists = reactorType.MethodByName("Outbox")
+	}
+
+	// reactors without a handle method react on the events of their 'On' methods
+	handleMethods := []reflect.Method{handleMethod}
 	if !exists {
+		handleMethods = onMethods(reactorType)
+	}
+	if len(handleMethods) == 0 {
 		return fmt.Errorf("reactor '%s' doesn't have a 'Handle' method", reactorTypeElem.Name())
 	}
 
-	// ensure that the handle method expects one argument
+	// the events the methods react on
+	handlers := map[reflect.Type]handler{}
+	for _, method := range handleMethods {
+
+		handleMethodEvent, err := handleMethodEvent(reactorTypeElem, method)
+		if err != nil {
+			return err
+		}
+
+		if _, exists := handlers[handleMethodEvent]; exists {
+			return fmt.Errorf("reactor %s has multiple methods for event '%s'", reactorTypeElem.Name(), handleMethodEvent.Name())
+		}
+
+		handlers[handleMethodEvent] = handler{
+			name:    reactorName(reactorValue),
+			reactor: reactorValue,
+			method:  method.Name,
+		}
+
+	}
+
+	// the timeout is passed on with the context - a reactor without one would hold its lane till it returns
+	reactorRegistration := newRegistration(options...)
+	if reactorRegistration.errorPolicy.Timeout > 0 {
+		for _, method := range handleMethods {
+			if method.Type.NumIn() != 3 {
+				return fmt.Errorf("reactor %s must expect a context in its '%s' method in order to be registered with a timeout", reactorTypeElem.Name(), method.Name)
+			}
+		}
+	}
+
+	// append reactor
+	for handleMethodEvent, handler := range handlers {
+		r.reactors[handleMethodEvent] = append(r.reactors[handleMethodEvent], handler)
+	}
+	r.registrations[reactorName(reactorValue)] = reactorRegistration
+
+	return nil
+
+}
+
+// The 'On' methods of the reactor type (e.g. 'OnUserCreated') that expect an event. Other methods (e.g. 'Online()' or
+// 'OnCall() bool') are no handlers and skipped.
+func onMethods(reactorType reflect.Type) []reflect.Method {
+
+	methods := []reflect.Method{}
+	for i := 0; i < reactorType.NumMethod(); i++ {
+
+		method := reactorType.Method(i)
+		if !dispatch.IsOnMethod(method.Name) || !dispatch.TakesEvent(method) {
+			continue
+		}
+
+		methods = append(methods, method)
+
+	}
+
+	return methods
+
+}
+
+// validate the handle method of the reactor and return the type of event it expects
+func handleMethodEvent(reactorTypeElem reflect.Type, handleMethod reflect.Method) (reflect.Type, error) {
+
+	// the context is optional for 'On' methods
+	parameters := 2
+	if handleMethod.Name == "HandleContext" || dispatch.IsOnMethod(handleMethod.Name) && handleMethod.Type.NumIn() == 3 {
+		parameters = 3
+	}
+
+	// outbox reactors must return the messages, the others may return follow up events and report an error
+	if handleMethod.Name == "Outbox" {
+		if handleMethod.Type.NumOut() != 1 || handleMethod.Type.Out(0) != messagesType {
+			return nil, fmt.Errorf("the 'Outbox' method of reactor %s must return the messages", reactorTypeElem.Name())
+		}
+	} else if !validResults(handleMethod.Type) {
+		return nil, fmt.Errorf("the handle method of reactor %s may only return follow up events and an error", reactorTypeElem.Name())
+	}
+
+	// ensure that the handle method expects one argument (besides the context)
 	// @todo figure out why this is two - makes no sense except for if the receiver is counted as an parameter too
-	if handleMethod.Type.NumIn() != 2 {
-		return fmt.Errorf("the handle method of reactor %s must expect exactly one parameter", reactorTypeElem.Name())
+	if handleMethod.Type.NumIn() != parameters {
+		return nil, fmt.Errorf("the handle method of reactor %s must expect exactly one parameter", reactorTypeElem.Name())
+	}
+
+	// ensure that the context is expected first
+	if parameters == 3 && handleMethod.Type.In(1) != contextType {
+		return nil, fmt.Errorf("the '%s' method of reactor %s must expect a context as first parameter", handleMethod.Name, reactorTypeElem.Name())
 	}
 
 	// ensure that the expected argument is an implementation of IESEvent
-	handleMethodEvent := handleMethod.Type.In(1)
+	handleMethodEvent := handleMethod.Type.In(parameters - 1)
 	if !handleMethodEvent.Implements(reflect.TypeOf((*event.IESEvent)(nil)).Elem()) {
-		return fmt.Errorf("the handle method expects '%s' which is not an IESImplementation", handleMethodEvent.Name())
+		return nil, fmt.Errorf("the handle method expects '%s' which is not an IESImplementation", handleMethodEvent.Name())
 	}
 
 	if handleMethodEvent.Kind() == reflect.Ptr {
 		handleMethodEvent = handleMethodEvent.Elem()
 	}
 
-	// append reactor
-	r.reactors[handleMethodEvent] = append(r.reactors[handleMethodEvent], reactorValue)
+	return handleMethodEvent, nil
 
-	return nil
+}
 
+// reactor together with its name
+type NamedReactor struct {
+	// name of the reactor type
+	Name   string
+	Handle reactor
+	// handle the event with the given context - it's only passed on to reactors that have a 'HandleContext' method
+	HandleContext contextReactor
+	// like HandleContext, but returns the follow up events of the reactor as well
+	React followUpReactor
+	// the messages the reactor wants to send - only set for reactors that have an 'Outbox' method
+	Outbox outboxReactor
 }
 
 // Fetch reactors for event
 func (r *Registry) Reactors(e event.IESEvent) []reactor {
 
+	reactors := []reactor{}
+	for _, namedReactor := range r.NamedReactors(e) {
+		reactors = append(reactors, namedReactor.Handle)
+	}
+
+	return reactors
+
+}
+
+// Fetch reactors for event together with their names
+func (r *Registry) NamedReactors(e event.IESEvent) []NamedReactor {
+
 	// lock
 	r.lock.Lock()
 	defer func() {
@@ -92,27 +265,193 @@ func (r *Registry) Reactors(e event.IESEvent) []reactor {
 	}
 
 	// reactor type factory
-	reactorTypeFactory := func(reactor reflect.Value) reactor {
-		return func(event event.IESEvent) {
-			reactor.MethodByName("Handle").Call([]reflect.Value{
+	reactorTypeFactory := func(handler handler) followUpReactor {
+		return func(ctx context.Context, event event.IESEvent) ([]event.IESEvent, error) {
+
+			// the context is passed in as first parameter in case the method expects it
+			method := handler.reactor.MethodByName(handler.method)
+			arguments := []reflect.Value{reflect.ValueOf(event)}
+			if method.Type().NumIn() == 2 {
+				arguments = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, arguments...)
+			}
+
+			return handleResults(method.Call(arguments))
+
+		}
+	}
+
+	// outbox reactor factory
+	outboxReactorFactory := func(reactor reflect.Value) outboxReactor {
+		return func(event event.IESEvent) []Message {
+			results := reactor.MethodByName("Outbox").Call([]reflect.Value{
 				reflect.ValueOf(event),
 			})
+			return results[0].Interface().([]Message)
 		}
 	}
 
 	// get reactors for event type
-	reactors := []reactor{}
-	for _, reactorValue := range r.reactors[eventType] {
-		reactors = append(reactors, reactorTypeFactory(reactorValue))
+	reactors := []NamedReactor{}
+	for _, handler := range r.reactors[eventType] {
+
+		// outbox reactors don't handle the event themselves
+		if handler.method == "Outbox" {
+			outbox := outboxReactorFactory(handler.reactor)
+			reactors = append(reactors, NamedReactor{
+				Name: handler.name,
+				Handle: func(event event.IESEvent) error {
+					outbox(event)
+					return nil
+				},
+				HandleContext: func(ctx context.Context, event event.IESEvent) error {
+					outbox(event)
+					return nil
+				},
+				React: func(ctx context.Context, event event.IESEvent) ([]event.IESEvent, error) {
+					outbox(event)
+					return nil, nil
+				},
+				Outbox: outbox,
+			})
+			continue
+		}
+
+		react := handler.react
+		if react == nil {
+			react = reactorTypeFactory(handler)
+		}
+		reactors = append(reactors, NamedReactor{
+			Name: handler.name,
+			Handle: func(event event.IESEvent) error {
+				_, err := react(context.Background(), event)
+				return err
+			},
+			HandleContext: func(ctx context.Context, event event.IESEvent) error {
+				_, err := react(ctx, event)
+				return err
+			},
+			React: react,
+		})
+
 	}
 
 	return reactors
 
 }
 
+// the error policy the reactor with the given name got registered with
+func (r *Registry) ErrorPolicy(reactorName string) ErrorPolicy {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	registration, exists := r.registrations[reactorName]
+	if !exists {
+		return newRegistration().errorPolicy
+	}
+
+	return registration.errorPolicy
+
+}
+
+// the execution policy the reactor with the given name got registered with
+func (r *Registry) ExecutionPolicy(reactorName string) ExecutionPolicy {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	registration, exists := r.registrations[reactorName]
+	if !exists {
+		return newRegistration().executionPolicy
+	}
+
+	return registration.executionPolicy
+
+}
+
+// names of all registered reactors (ordered)
+func (r *Registry) Names() []string {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	// reactors with multiple 'On' methods react on multiple events
+	unique := map[string]bool{}
+	names := []string{}
+	for _, handlers := range r.reactors {
+		for _, handler := range handlers {
+			if !unique[handler.name] {
+				unique[handler.name] = true
+				names = append(names, handler.name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return names
+
+}
+
+// Check the results of a handle method. Allowed are no results, an error, follow up events or follow up events
+// together with an error.
+func validResults(handleMethod reflect.Type) bool {
+
+	switch handleMethod.NumOut() {
+	case 0:
+		return true
+	case 1:
+		return handleMethod.Out(0) == errorType || handleMethod.Out(0) == eventsType
+	case 2:
+		return handleMethod.Out(0) == eventsType && handleMethod.Out(1) == errorType
+	default:
+		return false
+	}
+
+}
+
+// The follow up events and the error returned by a handle method. The reactor might neither return follow up events
+// nor report errors.
+func handleResults(results []reflect.Value) ([]event.IESEvent, error) {
+
+	var followUps []event.IESEvent
+	var err error
+	for _, result := range results {
+		switch {
+		case result.IsNil():
+		case result.Type() == eventsType:
+			followUps = result.Interface().([]event.IESEvent)
+		default:
+			err = result.Interface().(error)
+		}
+	}
+
+	return followUps, err
+
+}
+
+func reactorName(reactorValue reflect.Value) string {
+
+	if namedReactor, k := reactorValue.Interface().(INamedReactor); k {
+		return namedReactor.Name()
+	}
+
+	return reactorValue.Type().Elem().String()
+
+}
+
 func NewReactorRegistry() *Registry {
 	return &Registry{
-		lock:     &sync.Mutex{},
-		reactors: map[reflect.Type][]reflect.Value{},
+		lock:          &sync.Mutex{},
+		reactors:      map[reflect.Type][]handler{},
+		registrations: map[string]registration{},
 	}
 }
@@ -1,9 +1,12 @@
 package reactor
 
 import (
+	"context"
+	"errors"
 	"github.com/florianlenz/event-sourcing-go/event"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 // test reactor with invalid handle method to test certain behaviour
@@ -19,6 +22,123 @@ type testReactorWithTooManyParamsInHandleFunction struct {
 func (r *testReactorWithTooManyParamsInHandleFunction) Handle(firstArg interface{}, secondArg interface{}) {
 }
 
+// test reactor that expects the context in the wrong place
+type testReactorWithContextInWrongPlace struct {
+}
+
+func (r *testReactorWithContextInWrongPlace) HandleContext(e testEventOne, ctx context.Context) {}
+
+// test reactor that wants a context
+type testContextReactor struct {
+	handle func(ctx context.Context, e testEventOne)
+}
+
+func (r *testContextReactor) HandleContext(ctx context.Context, e testEventOne) {
+	r.handle(ctx, e)
+}
+
+// test reactor that reports an error
+type testFailingReactor struct {
+}
+
+func (r *testFailingReactor) Handle(e testEventOne) error {
+	return errors.New("mail server is down")
+}
+
+// test reactor that returns follow up events
+type testFollowUpReactor struct {
+}
+
+func (r *testFollowUpReactor) HandleContext(ctx context.Context, e testEventTwo) ([]event.IESEvent, error) {
+	return []event.IESEvent{testEventOne{}}, nil
+}
+
+// test reactor that reacts on multiple events
+type testMultiEventReactor struct {
+	reactedOn []string
+}
+
+func (r *testMultiEventReactor) OnEventOne(e testEventOne) error {
+	r.reactedOn = append(r.reactedOn, "one")
+	return nil
+}
+
+func (r *testMultiEventReactor) OnEventTwo(ctx context.Context, e testEventTwo) ([]event.IESEvent, error) {
+	r.reactedOn = append(r.reactedOn, "two")
+	return []event.IESEvent{testEventOne{}}, nil
+}
+
+// the helpers aren't handlers
+func (r *testMultiEventReactor) Online() bool {
+	return true
+}
+
+func (r *testMultiEventReactor) Options(e testEventOne) []string {
+	return nil
+}
+
+func (r *testMultiEventReactor) OnCall() bool {
+	return true
+}
+
+// test reactor with two methods for the same event
+type testAmbiguousReactor struct {
+}
+
+func (r *testAmbiguousReactor) OnEventOne(e testEventOne) {}
+
+func (r *testAmbiguousReactor) OnFirstEvent(e testEventOne) {}
+
+// test reactor with an invalid 'On' method
+type testReactorWithInvalidOnMethod struct {
+}
+
+func (r *testReactorWithInvalidOnMethod) OnEventOne(e testEventOne, ctx context.Context) {}
+
+// test reactor that returns something else than an error
+type testReactorWithInvalidResult struct {
+}
+
+func (r *testReactorWithInvalidResult) Handle(e testEventOne) string {
+	return ""
+}
+
+// test reactor that chooses its name
+type testNamedReactor struct {
+	name string
+}
+
+func (r *testNamedReactor) Name() string {
+	return r.name
+}
+
+func (r *testNamedReactor) Handle(e testEventTwo) {}
+
+// another test reactor that chooses its name
+type anotherTestNamedReactor struct {
+	name string
+}
+
+func (r *anotherTestNamedReactor) Name() string {
+	return r.name
+}
+
+func (r *anotherTestNamedReactor) Handle(e testEventTwo) {}
+
+// test reactor that sends its messages via the outbox
+type testOutboxReactor struct {
+}
+
+func (r *testOutboxReactor) Outbox(e testEventOne) []Message {
+	return []Message{{Topic: "mails"}}
+}
+
+// test outbox reactor that doesn't return the messages
+type testOutboxReactorWithoutMessages struct {
+}
+
+func (r *testOutboxReactorWithoutMessages) Outbox(e testEventOne) {}
+
 // test event one
 type testEventOne struct {
 	event.ESEvent
@@ -158,6 +278,215 @@ func TestReactorRegistry(t *testing.T) {
 
 			})
 
+			Convey("reactor names must be unique", func() {
+
+				rr := NewReactorRegistry()
+
+				So(rr.Register(&testReactorOne{}), ShouldBeNil)
+				So(rr.Register(&testNamedReactor{name: "mailer"}), ShouldBeNil)
+				So(rr.Register(&anotherTestNamedReactor{name: "mailer"}), ShouldBeError, "reactor with name 'mailer' has already been registered")
+
+				So(rr.Names(), ShouldResemble, []string{"mailer", "reactor.testReactorOne"})
+
+				reactors := rr.NamedReactors(testEventTwo{})
+				So(reactors, ShouldHaveLength, 1)
+				So(reactors[0].Name, ShouldEqual, "mailer")
+
+			})
+
+			Convey("collect the messages of outbox reactors", func() {
+
+				rr := NewReactorRegistry()
+
+				So(rr.Register(&testOutboxReactorWithoutMessages{}), ShouldBeError, "the 'Outbox' method of reactor testOutboxReactorWithoutMessages must return the messages")
+				So(rr.Register(&testOutboxReactor{}), ShouldBeNil)
+
+				reactors := rr.NamedReactors(testEventOne{})
+				So(reactors, ShouldHaveLength, 1)
+				So(reactors[0].Outbox(testEventOne{}), ShouldResemble, []Message{{Topic: "mails"}})
+
+			})
+
+			Convey("pass the context to reactors with a HandleContext method", func() {
+
+				rr := NewReactorRegistry()
+
+				So(rr.Register(&testReactorWithContextInWrongPlace{}), ShouldBeError, "the 'HandleContext' method of reactor testReactorWithContextInWrongPlace must expect a context as first parameter")
+
+				type key struct{}
+				values := []interface{}{}
+				So(rr.Register(&testContextReactor{
+					handle: func(ctx context.Context, e testEventOne) {
+						values = append(values, ctx.Value(key{}))
+					},
+				}), ShouldBeNil)
+
+				reactors := rr.NamedReactors(testEventOne{})
+				So(reactors, ShouldHaveLength, 1)
+				So(reactors[0].Name, ShouldEqual, "reactor.testContextReactor")
+
+				reactors[0].HandleContext(context.WithValue(context.Background(), key{}, "value"), testEventOne{})
+				reactors[0].Handle(testEventOne{})
+				So(values, ShouldResemble, []interface{}{"value", nil})
+
+			})
+
+			Convey("return the errors of reactors and remember their error policy", func() {
+
+				rr := NewReactorRegistry()
+
+				So(rr.Register(&testReactorWithInvalidResult{}), ShouldBeError, "the handle method of reactor testReactorWithInvalidResult may only return follow up events and an error")
+				So(rr.Register(&testFailingReactor{}, WithRetries(2, time.Second)), ShouldBeNil)
+				So(rr.Register(&testReactorTwo{handle: func(e testEventTwo) {}}), ShouldBeNil)
+				So(rr.Register(&testContextReactor{}, WithTimeout(time.Minute)), ShouldBeNil)
+
+				reactors := rr.NamedReactors(testEventOne{})
+				So(reactors, ShouldHaveLength, 2)
+				So(reactors[0].Handle(testEventOne{}), ShouldBeError, "mail server is down")
+				So(reactors[0].HandleContext(context.Background(), testEventOne{}), ShouldBeError, "mail server is down")
+				So(rr.NamedReactors(testEventTwo{})[0].Handle(testEventTwo{}), ShouldBeNil)
+
+				So(rr.ErrorPolicy("reactor.testFailingReactor"), ShouldResemble, ErrorPolicy{
+					Retries: 2,
+					Backoff: time.Second,
+				})
+				So(rr.ErrorPolicy("reactor.testContextReactor"), ShouldResemble, ErrorPolicy{
+					Timeout: time.Minute,
+				})
+				So(rr.ErrorPolicy("reactor.testReactorTwo"), ShouldResemble, ErrorPolicy{})
+
+			})
+
+			Convey("only reactors that expect a context can be registered with a timeout", func() {
+
+				rr := NewReactorRegistry()
+
+				// a blocking reactor would hold its lane forever since it doesn't get the timeout
+				blocking := &testReactorOne{handle: func(e testEventOne) {
+					select {}
+				}}
+				So(rr.Register(blocking, WithTimeout(time.Second)), ShouldBeError, "reactor testReactorOne must expect a context in its 'Handle' method in order to be registered with a timeout")
+				So(rr.Register(&testMultiEventReactor{}, WithTimeout(time.Second)), ShouldBeError, "reactor testMultiEventReactor must expect a context in its 'OnEventOne' method in order to be registered with a timeout")
+				So(rr.Register(&testOutboxReactor{}, WithTimeout(time.Second)), ShouldBeError, "reactor testOutboxReactor must expect a context in its 'Outbox' method in order to be registered with a timeout")
+				So(rr.NamedReactors(testEventOne{}), ShouldBeEmpty)
+
+				So(rr.Register(blocking), ShouldBeNil)
+				So(rr.Register(&testFollowUpReactor{}, WithTimeout(time.Second)), ShouldBeNil)
+
+			})
+
+			Convey("return the follow up events of reactors", func() {
+
+				rr := NewReactorRegistry()
+
+				So(rr.Register(&testFollowUpReactor{}), ShouldBeNil)
+				So(rr.Register(&testFailingReactor{}), ShouldBeNil)
+
+				reactors := rr.NamedReactors(testEventTwo{})
+				So(reactors, ShouldHaveLength, 1)
+				followUps, err := reactors[0].React(context.Background(), testEventTwo{})
+				So(err, ShouldBeNil)
+				So(followUps, ShouldResemble, []event.IESEvent{testEventOne{}})
+				So(reactors[0].Handle(testEventTwo{}), ShouldBeNil)
+
+				// reactors without follow up events
+				followUps, err = rr.NamedReactors(testEventOne{})[0].React(context.Background(), testEventOne{})
+				So(err, ShouldBeError, "mail server is down")
+				So(followUps, ShouldBeNil)
+
+			})
+
+			Convey("dispatch the events to the 'On' methods of multi event reactors", func() {
+
+				rr := NewReactorRegistry()
+
+				So(rr.Register(&testAmbiguousReactor{}), ShouldBeError, "reactor testAmbiguousReactor has multiple methods for event 'testEventOne'")
+				So(rr.Register(&testReactorWithInvalidOnMethod{}), ShouldBeError, "the 'OnEventOne' method of reactor testReactorWithInvalidOnMethod must expect a context as first parameter")
+
+				multiEventReactor := &testMultiEventReactor{}
+				So(rr.Register(multiEventReactor), ShouldBeNil)
+				So(rr.Names(), ShouldResemble, []string{"reactor.testMultiEventReactor"})
+
+				reactors := rr.NamedReactors(testEventOne{})
+				So(reactors, ShouldHaveLength, 1)
+				So(reactors[0].Handle(testEventOne{}), ShouldBeNil)
+
+				reactors = rr.NamedReactors(testEventTwo{})
+				So(reactors, ShouldHaveLength, 1)
+				So(reactors[0].Name, ShouldEqual, "reactor.testMultiEventReactor")
+				followUps, err := reactors[0].React(context.Background(), testEventTwo{})
+				So(err, ShouldBeNil)
+				So(followUps, ShouldResemble, []event.IESEvent{testEventOne{}})
+
+				So(multiEventReactor.reactedOn, ShouldResemble, []string{"one", "two"})
+
+			})
+
+			Convey("register functions that react on one type of event", func() {
+
+				type key struct{}
+				rr := NewReactorRegistry()
+
+				So(On(rr, "", func(ctx context.Context, e testEventOne) error { return nil }), ShouldBeError, "the name of the reactor must not be empty")
+				So(On(rr, "any-event", func(ctx context.Context, e event.IESEvent) error { return nil }), ShouldBeError, "reactor 'any-event' must react on a concrete event type")
+
+				reactedOn := []string{}
+				So(On(rr, "send-welcome-mail", func(ctx context.Context, e testEventOne) error {
+					reactedOn = append(reactedOn, ctx.Value(key{}).(string))
+					return errors.New("mail server is down")
+				}, WithRetries(2, time.Second), WithConcurrency(3)), ShouldBeNil)
+
+				// the name must be unique among all reactors
+				So(On(rr, "send-welcome-mail", func(ctx context.Context, e testEventTwo) error { return nil }), ShouldBeError, "reactor with name 'send-welcome-mail' has already been registered")
+				So(rr.Register(&testReactorOne{}), ShouldBeNil)
+				So(rr.Names(), ShouldResemble, []string{"reactor.testReactorOne", "send-welcome-mail"})
+
+				So(rr.ErrorPolicy("send-welcome-mail"), ShouldResemble, ErrorPolicy{Retries: 2, Backoff: time.Second})
+				So(rr.ExecutionPolicy("send-welcome-mail"), ShouldResemble, ExecutionPolicy{Concurrency: 3})
+
+				reactors := rr.NamedReactors(testEventOne{})
+				So(reactors, ShouldHaveLength, 2)
+				So(reactors[0].Name, ShouldEqual, "send-welcome-mail")
+				ctx := context.WithValue(context.Background(), key{}, "user-1")
+				followUps, err := reactors[0].React(ctx, testEventOne{})
+				So(followUps, ShouldBeNil)
+				So(err, ShouldBeError, "mail server is down")
+				So(reactedOn, ShouldResemble, []string{"user-1"})
+
+				So(rr.NamedReactors(testEventTwo{}), ShouldHaveLength, 0)
+
+			})
+
+			Convey("remember the execution policy of reactors", func() {
+
+				rr := NewReactorRegistry()
+
+				So(rr.Register(&testFailingReactor{}, WithConcurrency(4), WithStreamOrdering()), ShouldBeNil)
+				So(rr.Register(&testReactorTwo{handle: func(e testEventTwo) {}}, WithConcurrency(-1)), ShouldBeNil)
+
+				So(rr.ExecutionPolicy("reactor.testFailingReactor"), ShouldResemble, ExecutionPolicy{
+					Concurrency:    4,
+					StreamOrdering: true,
+				})
+				So(rr.ExecutionPolicy("reactor.testReactorTwo"), ShouldResemble, ExecutionPolicy{Concurrency: 1})
+				So(rr.ExecutionPolicy("reactor.unknown"), ShouldResemble, ExecutionPolicy{Concurrency: 1})
+
+			})
+
+			Convey("retry with exponential backoff", func() {
+
+				calls := 0
+				start := time.Now()
+				err := ErrorPolicy{Retries: 2, Backoff: time.Millisecond * 10}.Retry(context.Background(), func() error {
+					calls++
+					return errors.New("failed")
+				})
+				So(err, ShouldBeError, "failed")
+				So(calls, ShouldEqual, 3)
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Millisecond*30)
+
+			})
+
 		})
 
 	})
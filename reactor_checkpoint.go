@@ -0,0 +1,159 @@
+package es
+
+import (
+	"bytes"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/projector"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"sync"
+)
+
+// The checkpoint of a reactor is stored like the one of a projector, but in its own collection so that replays (which
+// reset the checkpoints of the projectors) don't touch it. The name is prefixed so that it can't collide with the
+// checkpoint of a projector in case a repository keeps both.
+type reactorCheckpoint struct {
+	reactorName string
+}
+
+func (c *reactorCheckpoint) Name() string {
+	return "reactor:" + c.reactorName
+}
+
+func (c *reactorCheckpoint) InterestedInEvents() []event.IESEvent {
+	return []event.IESEvent{}
+}
+
+func (c *reactorCheckpoint) Handle(event event.IESEvent) error {
+	return nil
+}
+
+// keeps the checkpoints of the reactors in the reactor_checkpoints collection
+func newReactorCheckpointRepository(db *mongo.Database, eventRegistry *event.Registry) projector.IProjectorRepository {
+	return projector.NewProjectorRepository(db.Collection("events"), db.Collection("reactor_checkpoints"), eventRegistry)
+}
+
+// check if the reactor already reacted on the event
+func (p *Processor) reacted(reactorName string, persistedEvent event.Event) bool {
+
+	checkpoint := p.reactorCheckpoint(reactorName)
+	if checkpoint == nil || persistedEvent.ID == nil {
+		return false
+	}
+
+	return bytes.Compare(persistedEvent.ID[:], checkpoint[:]) <= 0
+
+}
+
+// the last event the reactor reacted on (nil if it doesn't have a checkpoint yet)
+func (p *Processor) reactorCheckpoint(reactorName string) *primitive.ObjectID {
+
+	// lock
+	p.reactorCheckpointsLock.Lock()
+	defer func() {
+		p.reactorCheckpointsLock.Unlock()
+	}()
+
+	return p.reactorCheckpoints[reactorName]
+
+}
+
+// remember the last event the reactor reacted on
+func (p *Processor) setReactorCheckpoint(reactorName string, checkpoint *primitive.ObjectID) {
+
+	// lock
+	p.reactorCheckpointsLock.Lock()
+	defer func() {
+		p.reactorCheckpointsLock.Unlock()
+	}()
+
+	p.reactorCheckpoints[reactorName] = checkpoint
+
+}
+
+// move the checkpoint of the reactor - it never moves back
+func (p *Processor) updateReactorCheckpoint(reactorName string, persistedEvent event.Event) {
+
+	if persistedEvent.ID == nil || p.reacted(reactorName, persistedEvent) {
+		return
+	}
+
+	if err := p.reactorCheckpointRepository.UpdateLastHandledEvent(&reactorCheckpoint{reactorName: reactorName}, persistedEvent); err != nil {
+		p.logger.Error(err)
+		return
+	}
+
+	p.setReactorCheckpoint(reactorName, persistedEvent.ID)
+
+}
+
+// Hand the events that got committed after the checkpoints of the reactors, but weren't reacted on (e.g. because the
+// application crashed), over to the workers of the reactors. Reactors without a checkpoint start with the events that
+// are processed from now on. Returns the last event handed over per reactor - the checkpoints only move once the
+// workers are done, which is signaled by the returned wait group. Only called by the reactor go routine.
+func (p *Processor) catchUpReactors() (map[string]primitive.ObjectID, *sync.WaitGroup) {
+
+	caughtUp := map[string]primitive.ObjectID{}
+	done := &sync.WaitGroup{}
+
+	// load the checkpoints
+	var from *primitive.ObjectID
+	for _, reactorName := range p.reactorRegistry.Names() {
+
+		checkpoint, err := p.reactorCheckpointRepository.LastHandledEvent(&reactorCheckpoint{reactorName: reactorName})
+		if err != nil {
+			p.logger.Error(err)
+			continue
+		}
+		if checkpoint == nil {
+			continue
+		}
+
+		p.setReactorCheckpoint(reactorName, checkpoint)
+		if from == nil || bytes.Compare(checkpoint[:], from[:]) < 0 {
+			from = checkpoint
+		}
+
+	}
+
+	if from == nil {
+		return caughtUp, done
+	}
+
+	err := p.eventRepository.Map(event.Query{After: from}, func(persistedEvent event.Event) {
+
+		esEvent, err := p.eventRegistry.EventToESEvent(persistedEvent)
+		if err != nil {
+			p.logger.Error(err)
+			return
+		}
+
+		for _, reactor := range p.reactorRegistry.NamedReactors(esEvent) {
+
+			// reactors without a checkpoint only react on new events
+			if p.reactorCheckpoint(reactor.Name) == nil || p.reacted(reactor.Name, persistedEvent) {
+				continue
+			}
+
+			done.Add(1)
+			p.reactorWorker(reactor.Name).jobs <- reactorJob{
+				ctx:              p.ctx,
+				persistedEvent:   persistedEvent,
+				esEvent:          esEvent,
+				reactor:          reactor,
+				done:             done,
+				report:           newReport(*persistedEvent.ID),
+				updateCheckpoint: true,
+			}
+			caughtUp[reactor.Name] = *persistedEvent.ID
+
+		}
+
+	})
+	if err != nil {
+		p.logger.Error(err)
+	}
+
+	return caughtUp, done
+
+}
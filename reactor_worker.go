@@ -0,0 +1,207 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/reactor"
+	"sync"
+	"time"
+)
+
+type reactorJob struct {
+	ctx            context.Context
+	persistedEvent event.Event
+	esEvent        event.IESEvent
+	reactor        reactor.NamedReactor
+	done           *sync.WaitGroup
+	report         *Report
+	// move the checkpoint of the reactor (false if the reactor reacted on the event before)
+	updateCheckpoint bool
+	// receives the result of reacting on the event
+	result chan reactorResult
+}
+
+// the result of a reactor reacting on an event
+type reactorResult struct {
+	// the messages of an outbox reactor
+	messages []reactor.Message
+	// the events the reactor wants to commit
+	followUps []event.IESEvent
+	err       error
+	// amount of times the reactor tried to react on the event
+	attempts int
+}
+
+// Reacts on the events with one reactor in the background, so that a slow reactor neither blocks the projectors nor
+// the other reactors. Depending on the execution policy of the reactor it reacts on multiple events at the same time.
+type reactorWorker struct {
+	jobs      chan reactorJob
+	queueSize int
+	policy    reactor.ExecutionPolicy
+}
+
+// Dispatches the events to the lanes of the reactor. Events of the same lane are reacted on in order. The checkpoint
+// of the reactor is moved in the order the events got processed so that it never skips an event that is still
+// handled by another lane.
+func (w *reactorWorker) run(p *Processor) {
+
+	// with stream ordering each lane has its own go routine, otherwise all go routines share one lane
+	lanes, routinesPerLane := 1, w.policy.Concurrency
+	if w.policy.StreamOrdering {
+		lanes, routinesPerLane = w.policy.Concurrency, 1
+	}
+
+	// start the lanes
+	laneJobs := make([]chan reactorJob, lanes)
+	for i := range laneJobs {
+		laneJobs[i] = make(chan reactorJob, w.queueSize)
+		for routine := 0; routine < routinesPerLane; routine++ {
+			go func(jobs chan reactorJob) {
+				for job := range jobs {
+					job.result <- p.handleReaction(job.ctx, job.esEvent, job.reactor)
+				}
+			}(laneJobs[i])
+		}
+	}
+
+	// commit the results in the order the events got dispatched
+	committed := make(chan reactorJob, w.queueSize*w.policy.Concurrency)
+	committerDone := make(chan struct{})
+	go func() {
+
+		defer close(committerDone)
+
+		for job := range committed {
+			p.commitReaction(job.persistedEvent, job.reactor, <-job.result, job.report, job.updateCheckpoint)
+			job.done.Done()
+		}
+
+	}()
+
+	for job := range w.jobs {
+
+		job.result = make(chan reactorResult, 1)
+		committed <- job
+
+		lane := 0
+		if w.policy.StreamOrdering {
+			lane = partition(streamID(job.esEvent), lanes)
+		}
+		laneJobs[lane] <- job
+
+	}
+
+	// shut down the lanes and the committer
+	for _, jobs := range laneJobs {
+		close(jobs)
+	}
+	close(committed)
+	<-committerDone
+
+}
+
+// the id of the stream the event belongs to (empty if the event doesn't belong to a stream)
+func streamID(esEvent event.IESEvent) string {
+
+	if streamEvent, k := esEvent.(event.IStreamEvent); k {
+		return streamEvent.StreamID()
+	}
+
+	return ""
+
+}
+
+// Let the reactor react on the event according to its error policy. The amount of reactors that react at the same
+// time is limited by the reactor workers of the processor.
+func (p *Processor) handleReaction(ctx context.Context, esEvent event.IESEvent, namedReactor reactor.NamedReactor) reactorResult {
+
+	p.reactorSlots <- struct{}{}
+	defer func() {
+		<-p.reactorSlots
+	}()
+
+	// a panicking reactor must not stop the other reactors
+	if namedReactor.Outbox != nil {
+		result := reactorResult{attempts: 1}
+		result.err = recovered(func() error {
+			result.messages = namedReactor.Outbox(esEvent)
+			return nil
+		})
+		return result
+	}
+
+	policy := p.reactorRegistry.ErrorPolicy(namedReactor.Name)
+	result := reactorResult{attempts: policy.Retries + 1}
+	result.err = policy.Retry(ctx, func() error {
+		var err error
+		result.followUps, err = callReactor(ctx, policy.Timeout, namedReactor, esEvent)
+		return err
+	})
+
+	return result
+
+}
+
+// Move the checkpoint of the reactor if requested and record the result in the report. The messages of outbox
+// reactors are stored together with the checkpoint, the follow up events are committed before the checkpoint moves.
+// Events the reactor failed to react on are recorded as dead letter.
+func (p *Processor) commitReaction(persistedEvent event.Event, namedReactor reactor.NamedReactor, result reactorResult, report *Report, updateCheckpoint bool) {
+
+	err := result.err
+	if namedReactor.Outbox != nil {
+		if err == nil {
+			err = p.storeOutbox(namedReactor.Name, persistedEvent, result.messages, updateCheckpoint)
+		}
+	} else {
+		if err == nil && len(result.followUps) > 0 {
+			err = p.commitFollowUps(namedReactor.Name, persistedEvent, result.followUps)
+		}
+		if updateCheckpoint {
+			p.updateReactorCheckpoint(namedReactor.Name, persistedEvent)
+		}
+	}
+
+	if err != nil {
+		p.deadLetter(persistedEvent, "", namedReactor.Name, err, result.attempts)
+		err = fmt.Errorf("reactor '%s' failed to react on event with name '%s': %s", namedReactor.Name, persistedEvent.Name, err)
+		p.logger.Error(err)
+	}
+
+	report.reacted(namedReactor.Name, err)
+
+}
+
+// Let the reactor react on the event and return its follow up events. The context is cancelled once the timeout is
+// exceeded (in case there is one) - an attempt that failed after that counts as timed out. The reactor keeps its slot
+// and lane till it returned, so that retries and later events never overlap with it. Reactors are expected to honour
+// the context.
+func callReactor(ctx context.Context, timeout time.Duration, namedReactor reactor.NamedReactor, esEvent event.IESEvent) ([]event.IESEvent, error) {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var followUps []event.IESEvent
+	err := recovered(func() error {
+		var err error
+		followUps, err = namedReactor.React(ctx, esEvent)
+		return err
+	})
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("reactor '%s' didn't finish in time: %s", namedReactor.Name, ctx.Err())
+	}
+
+	return followUps, err
+
+}
+
+func newReactorWorker(policy reactor.ExecutionPolicy, queueSize int) *reactorWorker {
+	return &reactorWorker{
+		jobs:      make(chan reactorJob, queueSize),
+		queueSize: queueSize,
+		policy:    policy,
+	}
+}
@@ -1,56 +1,593 @@
 package es
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/florianlenz/event-sourcing-go/event"
 	"github.com/florianlenz/event-sourcing-go/projector"
 	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-func Replay(logger ILogger, db *mongo.Database, projectorRegistry *projector.Registry, eventRegistry *event.Registry) <-chan error {
+// progress of a running replay
+type ReplayProgress struct {
+	// amount of replayed events (including the events replayed before the replay got interrupted)
+	Processed int64
+	// total amount of events to replay
+	Total int64
+	// replayed events per second
+	Rate float64
+	// estimated time till the replay is done
+	ETA time.Duration
+}
+
+func newReplayProgress(processed, total, processedInRun int64, elapsed time.Duration) ReplayProgress {
+
+	progress := ReplayProgress{
+		Processed: processed,
+		Total:     total,
+	}
+
+	if elapsed > 0 {
+		progress.Rate = float64(processedInRun) / elapsed.Seconds()
+	}
+
+	// events committed while replaying are replayed as well, so we might end up with more events than we counted
+	remaining := total - processed
+	if remaining > 0 && progress.Rate > 0 {
+		progress.ETA = time.Duration(float64(remaining) / progress.Rate * float64(time.Second))
+	}
+
+	return progress
+
+}
+
+type replayConfig struct {
+	progressInterval   time.Duration
+	onProgress         func(progress ReplayProgress)
+	checkpointInterval int64
+	fromScratch        bool
+	concurrency        int
+	// maximum amount of events handed over to a batch projector at once
+	batchSize int
+	// events to replay
+	query event.Query
+	// names of the projectors to replay (all projectors are replayed if empty)
+	projectors []string
+	// database the checkpoints are written to (defaults to the database the events are read from)
+	target *mongo.Database
+	// keep the checkpoints in memory
+	inMemory bool
+	// keeps the checkpoints of the live projectors (defaults to the projectors collection)
+	projectorRepository projector.IProjectorRepository
+	// called once the replay lock got acquired - the replay is aborted in case it fails
+	onLocked func() error
+	// time the processors get to notice the replay lock before the projectors are touched
+	lockGracePeriod time.Duration
+}
+
+// replays that write into the live projectors must pause the live processors
+func (c *replayConfig) replaysLiveProjectors() bool {
+	return c.target == nil && !c.inMemory
+}
+
+// A partial replay doesn't start with the first event of its projectors. It's used to repair projectors which is why it
+// neither resets nor moves the projector checkpoints.
+func (c *replayConfig) partial() bool {
+	return len(c.query.Names) > 0 || c.query.OccurredFrom != 0
+}
+
+// A replay that stops before the last event rebuilds its projectors to a past state. The live processors continue after
+// the last replayed event, so the events after the end would never be applied to the live projectors.
+func (c *replayConfig) rewindsLiveProjectors() bool {
+	bounded := c.query.Until != nil || c.query.OccurredTo != 0
+	return bounded && !c.partial() && c.replaysLiveProjectors()
+}
+
+// name of the replay checkpoint - replays with different filters must not resume each other
+func (c *replayConfig) checkpointName() string {
+
+	values := url.Values{}
+
+	if len(c.query.Names) > 0 {
+		values.Set("events", sortedList(c.query.Names))
+	}
+	if c.query.OccurredFrom != 0 {
+		values.Set("from", strconv.FormatInt(c.query.OccurredFrom, 10))
+	}
+	if c.query.OccurredTo != 0 {
+		values.Set("to", strconv.FormatInt(c.query.OccurredTo, 10))
+	}
+	if c.query.Until != nil {
+		values.Set("until", c.query.Until.Hex())
+	}
+	if len(c.projectors) > 0 {
+		values.Set("projectors", sortedList(c.projectors))
+	}
+
+	if len(values) == 0 {
+		return "replay"
+	}
+
+	return "replay?" + values.Encode()
+
+}
+
+func sortedList(values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+type ReplayOption func(config *replayConfig)
+
+// report the progress of the replay in the given interval. The progress is reported one last time when the replay is done.
+func ReplayWithProgress(interval time.Duration, onProgress func(progress ReplayProgress)) ReplayOption {
+	return func(config *replayConfig) {
+		config.progressInterval = interval
+		config.onProgress = onProgress
+	}
+}
+
+// persist a checkpoint every n replayed events (defaults to 1000). An interrupted replay continues after the last checkpoint.
+func ReplayWithCheckpointInterval(events int64) ReplayOption {
+	return func(config *replayConfig) {
+		config.checkpointInterval = events
+	}
+}
+
+// the maximum amount of projectors that handle events at the same time (defaults to the number of CPUs).
+// Each projector still handles its events in order.
+func ReplayWithConcurrency(projectors int) ReplayOption {
+	return func(config *replayConfig) {
+		config.concurrency = projectors
+	}
+}
+
+// the maximum amount of events that are handed over to projectors that implement projector.IBatchProjector at once
+// (defaults to 1000). The checkpoint of such a projector is updated once per batch.
+func ReplayWithBatchSize(events int) ReplayOption {
+	return func(config *replayConfig) {
+		config.batchSize = events
+	}
+}
+
+// only replay the events with the given names. The projector checkpoints are neither reset nor moved by such a replay.
+func ReplayEvents(names ...string) ReplayOption {
+	return func(config *replayConfig) {
+		config.query.Names = names
+	}
+}
+
+// only replay the events that occurred in the given time range (bounds are inclusive - pass a zero time to leave a side open).
+// The projector checkpoints are neither reset nor moved by a replay that has a start. A replay that only has an end must
+// be combined with ReplayInto or ReplayInMemory - it can't rebuild the live projectors.
+func ReplayOccurredBetween(from, to time.Time) ReplayOption {
+	return func(config *replayConfig) {
+		config.query.OccurredFrom = 0
+		if !from.IsZero() {
+			config.query.OccurredFrom = from.Unix()
+		}
+		config.query.OccurredTo = 0
+		if !to.IsZero() {
+			config.query.OccurredTo = to.Unix()
+		}
+	}
+}
+
+// only replay the events up to (and including) the given event. Must be combined with ReplayInto, ReplayInMemory or
+// ReplayEvents - the live projectors can't be rebuilt to a past state.
+func ReplayUntil(eventID primitive.ObjectID) ReplayOption {
+	return func(config *replayConfig) {
+		config.query.Until = &eventID
+	}
+}
+
+// only replay the projectors with the given names. All other projectors are left untouched.
+func ReplayProjectors(names ...string) ReplayOption {
+	return func(config *replayConfig) {
+		config.projectors = names
+	}
+}
+
+// write the projector and replay checkpoints into the given database instead of the one the events are read from.
+// Combined with projectors that write their read models into the same database (see ReplayTarget) this replays into a
+// sandbox and leaves the live projectors untouched. Projectors that ignore the target write into the live read models -
+// without holding the replay lock, so the live processors keep writing into them as well.
+func ReplayInto(db *mongo.Database) ReplayOption {
+	return func(config *replayConfig) {
+		config.target = db
+	}
+}
+
+// keep the projector and replay checkpoints in memory. Such a replay leaves the checkpoints of the live projectors
+// untouched but can't be resumed. Projectors must check ReplayTarget - they would otherwise write into the live read
+// models without holding the replay lock.
+func ReplayInMemory() ReplayOption {
+	return func(config *replayConfig) {
+		config.inMemory = true
+	}
+}
+
+type replayTargetKey struct{}
+
+// target of a replay into a sandbox
+type replayTarget struct {
+	db *mongo.Database
+}
+
+// context the replayed events are handled with
+func (c *replayConfig) handlerContext() context.Context {
+
+	if c.replaysLiveProjectors() {
+		return context.Background()
+	}
+
+	return context.WithValue(context.Background(), replayTargetKey{}, &replayTarget{db: c.target})
+
+}
+
+// Get the target of the replay the event is handled by. Projectors that implement projector.IContextProjector (or
+// projector.ITransactionalProjector via tx.Context()) receive it with the handler context. sandboxed is true in case
+// the event is replayed via ReplayInto or ReplayInMemory - the projector must write its read model into db then
+// (db is nil for in memory replays) and must not touch the live read models.
+func ReplayTarget(ctx context.Context) (db *mongo.Database, sandboxed bool) {
+
+	target, sandboxed := ctx.Value(replayTargetKey{}).(*replayTarget)
+	if !sandboxed {
+		return nil, false
+	}
+
+	return target.db, true
+
+}
+
+// ignore the checkpoint of an interrupted replay and start over
+func ReplayFromScratch() ReplayOption {
+	return func(config *replayConfig) {
+		config.fromScratch = true
+	}
+}
+
+func Replay(logger ILogger, db *mongo.Database, projectorRegistry *projector.Registry, eventRegistry *event.Registry, options ...ReplayOption) <-chan error {
+
+	// replay config
+	config := &replayConfig{
+		checkpointInterval: 1000,
+		concurrency:        runtime.NumCPU(),
+		batchSize:          1000,
+		lockGracePeriod:    replayLockPollInterval * 2,
+	}
+	for _, option := range options {
+		option(config)
+	}
+	if config.concurrency < 1 {
+		config.concurrency = 1
+	}
+	if config.batchSize < 1 {
+		config.batchSize = 1
+	}
+
+	done := make(chan error, 1)
+
+	// start background re playing
+	go func() {
+		done <- replay(logger, db, projectorRegistry, eventRegistry, config)
+	}()
+
+	return done
+
+}
+
+func replay(logger ILogger, db *mongo.Database, projectorRegistry *projector.Registry, eventRegistry *event.Registry, config *replayConfig) error {
+
+	if config.rewindsLiveProjectors() {
+		return errors.New("a replay that stops before the last event can't rebuild the live projectors - replay into another database or in memory")
+	}
+
+	// the database the checkpoints are written to
+	target := db
+	if config.target != nil {
+		target = config.target
+	}
 
 	// collections
 	eventCollection := db.Collection("events")
-	projectorCollection := db.Collection("projectors")
+	projectorCollection := target.Collection("projectors")
+	checkpointCollection := target.Collection("replay_checkpoints")
+	deadLetterCollection := target.Collection("dead_letters")
 
 	// repositories
 	eventRepository := event.NewEventRepository(eventCollection)
-	projectorRepository := projector.NewProjectorRepository(eventCollection, projectorCollection, eventRegistry)
+	var projectorRepository projector.IProjectorRepository = projector.NewProjectorRepository(eventCollection, projectorCollection, eventRegistry)
+	var checkpointRepository replayCheckpointRepository = newReplayCheckpointRepository(checkpointCollection)
+	var deadLetterRepository deadLetterRepository = newDeadLetterRepository(deadLetterCollection)
+	if config.inMemory {
+		projectorRepository = projector.NewMemoryProjectorRepository(eventRepository, eventRegistry)
+		checkpointRepository = newMemoryReplayCheckpointRepository()
+		// the failed events are only logged
+		deadLetterRepository = nil
+	}
+	if config.projectorRepository != nil && config.replaysLiveProjectors() {
+		projectorRepository = config.projectorRepository
+	}
 
-	done := make(chan error, 1)
+	// projectors to replay
+	projectors, err := selectProjectors(projectorRegistry, config.projectors)
+	if err != nil {
+		return err
+	}
+
+	// the live processors must pause while we replay into the live projectors
+	handover := &replayHandover{
+		Projectors: config.projectors,
+		Events:     config.query.Names,
+	}
+	if config.replaysLiveProjectors() {
 
-	// processor (nill is passed for the reactor registry since we don't need it when we replay)
-	processor := newProcessor(projectorRegistry, eventRegistry, nil, projectorRepository, eventRepository, logger, true)
-	processor.Start()
+		lock := newReplayLockHolder(newReplayLockRepository(db.Collection("locks")), logger)
+		if err := lock.acquire(); err != nil {
+			return err
+		}
+
+		// hand over to the live processors after the last replayed event
+		defer func() {
+			lock.release(handover)
+		}()
+
+		if config.onLocked != nil {
+			if err := config.onLocked(); err != nil {
+				return err
+			}
+		}
+
+		// the processors only poll the replay lock - wait till they paused before we touch the projectors
+		time.Sleep(config.lockGracePeriod)
 
-	// drop all projectors
-	if err := projectorRepository.Drop(); err != nil {
-		done <- err
-		return done
 	}
 
-	// start background re playing
-	go func() {
+	// checkpoint of an interrupted replay
+	checkpointName := config.checkpointName()
+	checkpoint, err := checkpointRepository.Fetch(checkpointName)
+	if err != nil {
+		return err
+	}
+
+	// start over in case there is nothing to resume
+	if checkpoint == nil || config.fromScratch {
+
+		// delete the old checkpoint first - we would otherwise resume on top of reset projectors
+		if err := checkpointRepository.Delete(checkpointName); err != nil {
+			return err
+		}
+
+		// reset the projectors we replay
+		if err := resetProjectors(config, projectorRepository, projectors); err != nil {
+			return err
+		}
+
+		// persist the initial checkpoint so that we don't reset the projectors again when resuming
+		checkpoint = &replayCheckpoint{
+			Name: checkpointName,
+		}
+		if err := checkpointRepository.Save(*checkpoint); err != nil {
+			return err
+		}
+
+	}
+
+	// only replay the events after the checkpoint
+	query := config.query
+	query.After = checkpoint.LastReplayedEvent
+
+	// count the events to replay
+	remaining, err := eventRepository.Count(query)
+	if err != nil {
+		return err
+	}
+	total := checkpoint.Processed + remaining
+
+	// streaming the events stops once a projector failed
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	// one worker per projector
+	workers := map[string]*replayWorker{}
+	for _, projector := range projectors {
 
-		// map over the events an project them
-		err := eventRepository.Map(func(eventID primitive.ObjectID) {
+		worker := newReplayWorker(projector, projectorRegistry.ErrorPolicy(projector), deadLetterRepository, !config.partial(), config.batchSize)
+		worker.abort = abort
+		worker.ctx = config.handlerContext()
 
-			// tell processor to process event
-			onProcessed := processor.Process(eventID)
+		// The projectors move their checkpoints with every event while the replay checkpoint is only persisted every
+		// now and then. A resumed replay skips the events a projector handled before it got interrupted.
+		if !config.partial() {
+			worker.handledUntil, err = projectorRepository.LastHandledEvent(projector)
+			if err != nil {
+				return err
+			}
+		}
 
-			// wait till it got processed
-			<-onProcessed
+		workers[projector.Name()] = worker
 
+	}
+
+	// keeps track of the events that got replayed by all of their projectors
+	tracker := newReplayTracker(checkpoint.LastReplayedEvent, checkpoint.Processed)
+
+	// limits the amount of projectors that handle events at the same time
+	semaphore := make(chan struct{}, config.concurrency)
+
+	// start workers
+	wg := &sync.WaitGroup{}
+	for _, worker := range workers {
+		wg.Add(1)
+		go func(worker *replayWorker) {
+			defer wg.Done()
+			worker.run(projectorRepository, logger, semaphore, tracker)
+		}(worker)
+	}
+
+	startedAt := time.Now()
+	lastReport := startedAt
+	processedBeforeRun := checkpoint.Processed
+	lastPersistedCheckpoint := checkpoint.Processed
+
+	// report the progress to the progress listener
+	reportProgress := func() {
+		if config.onProgress == nil {
+			return
+		}
+		lastReport = time.Now()
+		_, processed := tracker.checkpoint()
+		config.onProgress(newReplayProgress(processed, total, processed-processedBeforeRun, time.Since(startedAt)))
+	}
+
+	// persist the checkpoint of the tracker
+	persistCheckpoint := func() error {
+		lastReplayedEvent, processed := tracker.checkpoint()
+		lastPersistedCheckpoint = processed
+		return checkpointRepository.Save(replayCheckpoint{
+			Name:              checkpointName,
+			LastReplayedEvent: lastReplayedEvent,
+			Processed:         processed,
 		})
+	}
 
-		// finish replay
-		done <- err
+	// stream the events to the workers of the projectors that are interested in them
+	err = eventRepository.MapContext(ctx, query, func(persistedEvent event.Event) {
 
-		// stop processor
-		processor.Stop()
+		// transform persisted event to event sourcing event
+		esEvent, err := eventRegistry.EventToESEvent(persistedEvent)
+		if err != nil {
+			logger.Error(err)
+			tracker.add(*persistedEvent.ID, 0)
+			return
+		}
 
-	}()
+		// workers of the projectors that are interested in the event
+		interestedWorkers := []*replayWorker{}
+		for _, projector := range projectorRegistry.ProjectorsForEvent(esEvent) {
+			if worker, exists := workers[projector.Name()]; exists {
+				interestedWorkers = append(interestedWorkers, worker)
+			}
+		}
 
-	return done
+		seq := tracker.add(*persistedEvent.ID, len(interestedWorkers))
+
+		for _, worker := range interestedWorkers {
+			worker.events <- replayEvent{
+				seq:            seq,
+				persistedEvent: persistedEvent,
+				esEvent:        esEvent,
+			}
+		}
+
+		// persist checkpoint
+		if _, processed := tracker.checkpoint(); config.checkpointInterval > 0 && processed-lastPersistedCheckpoint >= config.checkpointInterval {
+			if err := persistCheckpoint(); err != nil {
+				logger.Error(err)
+			}
+		}
+
+		// report progress
+		if time.Since(lastReport) >= config.progressInterval {
+			reportProgress()
+		}
+
+	})
+
+	// wait till the workers handled all events
+	for _, worker := range workers {
+		close(worker.events)
+	}
+	wg.Wait()
+
+	handover.Event, _ = tracker.checkpoint()
+
+	// the replay checkpoint stopped at the first event a projector failed to replay
+	if err == context.Canceled {
+		err = nil
+	}
+	for _, projector := range projectors {
+		if workerErr := workers[projector.Name()].err; err == nil && workerErr != nil {
+			err = workerErr
+		}
+	}
+
+	// persist the checkpoint so that we can resume from where we stopped
+	if err != nil {
+		if saveErr := persistCheckpoint(); saveErr != nil {
+			logger.Error(saveErr)
+		}
+		return err
+	}
+
+	reportProgress()
+
+	// finish replay
+	return checkpointRepository.Delete(checkpointName)
+
+}
+
+// the registered projectors with the given names (all projectors if no names are given)
+func selectProjectors(projectorRegistry *projector.Registry, names []string) ([]projector.IProjector, error) {
+
+	projectors := projectorRegistry.Projectors()
+	if len(names) == 0 {
+		return projectors, nil
+	}
+
+	selectedProjectors := []projector.IProjector{}
+
+	for _, name := range names {
+
+		found := false
+		for _, p := range projectors {
+			if p.Name() == name {
+				selectedProjectors = append(selectedProjectors, p)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("projector '%s' hasn't been registered", name)
+		}
+
+	}
+
+	return selectedProjectors, nil
+
+}
+
+// reset the checkpoints of the replayed projectors so that they start over
+func resetProjectors(config *replayConfig, projectorRepository projector.IProjectorRepository, projectors []projector.IProjector) error {
+
+	// partial replays don't touch the checkpoints
+	if config.partial() {
+		return nil
+	}
+
+	// drop all projectors in case we replay all of them
+	if len(config.projectors) == 0 {
+		return projectorRepository.Drop()
+	}
+
+	for _, p := range projectors {
+		if err := projectorRepository.Reset(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
 
 }
@@ -0,0 +1,145 @@
+package es
+
+import (
+	"context"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+	"sync"
+)
+
+type replayCheckpoint struct {
+	ID                *primitive.ObjectID `bson:"_id,omitempty"`
+	Name              string              `bson:"name"`
+	LastReplayedEvent *primitive.ObjectID `bson:"last_replayed_event"`
+	Processed         int64               `bson:"processed"`
+}
+
+type replayCheckpointRepository interface {
+	// fetch the checkpoint of an interrupted replay - nil is returned if there is none
+	Fetch(name string) (*replayCheckpoint, error)
+	// save the checkpoint
+	Save(checkpoint replayCheckpoint) error
+	// delete the checkpoint
+	Delete(name string) error
+}
+
+type mongoReplayCheckpointRepository struct {
+	checkpointCollection *mongo.Collection
+}
+
+func (r *mongoReplayCheckpointRepository) Fetch(name string) (*replayCheckpoint, error) {
+
+	// fetch checkpoint
+	result := r.checkpointCollection.FindOne(context.Background(), bson.M{
+		"name": name,
+	})
+
+	checkpoint := &replayCheckpoint{}
+
+	// decode fetched checkpoint
+	err := result.Decode(checkpoint)
+	switch err {
+	case nil:
+		return checkpoint, nil
+	case mongo.ErrNoDocuments:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+}
+
+func (r *mongoReplayCheckpointRepository) Save(checkpoint replayCheckpoint) error {
+
+	// create checkpoint if it doesn't exist
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	_, err := r.checkpointCollection.UpdateOne(
+		context.Background(),
+		bson.M{"name": checkpoint.Name},
+		bson.M{
+			"$set": bson.M{
+				"last_replayed_event": checkpoint.LastReplayedEvent,
+				"processed":           checkpoint.Processed,
+			},
+		},
+		updateOptions,
+	)
+
+	return err
+
+}
+
+func (r *mongoReplayCheckpointRepository) Delete(name string) error {
+	_, err := r.checkpointCollection.DeleteOne(context.Background(), bson.M{
+		"name": name,
+	})
+	return err
+}
+
+func newReplayCheckpointRepository(checkpointCollection *mongo.Collection) *mongoReplayCheckpointRepository {
+	return &mongoReplayCheckpointRepository{
+		checkpointCollection: checkpointCollection,
+	}
+}
+
+// keeps the replay checkpoints in memory
+type memoryReplayCheckpointRepository struct {
+	lock        *sync.Mutex
+	checkpoints map[string]replayCheckpoint
+}
+
+func (r *memoryReplayCheckpointRepository) Fetch(name string) (*replayCheckpoint, error) {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	checkpoint, exists := r.checkpoints[name]
+	if !exists {
+		return nil, nil
+	}
+
+	return &checkpoint, nil
+
+}
+
+func (r *memoryReplayCheckpointRepository) Save(checkpoint replayCheckpoint) error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	r.checkpoints[checkpoint.Name] = checkpoint
+
+	return nil
+
+}
+
+func (r *memoryReplayCheckpointRepository) Delete(name string) error {
+
+	// lock
+	r.lock.Lock()
+	defer func() {
+		r.lock.Unlock()
+	}()
+
+	delete(r.checkpoints, name)
+
+	return nil
+
+}
+
+func newMemoryReplayCheckpointRepository() *memoryReplayCheckpointRepository {
+	return &memoryReplayCheckpointRepository{
+		lock:        &sync.Mutex{},
+		checkpoints: map[string]replayCheckpoint{},
+	}
+}
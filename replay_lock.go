@@ -0,0 +1,66 @@
+package es
+
+import (
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"time"
+)
+
+// time after which the lock of a crashed replay expires
+const replayLockTTL = time.Minute
+
+// holds the replay lock and keeps it alive till it gets released
+type replayLockHolder struct {
+	repository replayLockRepository
+	logger     ILogger
+	owner      string
+	stop       chan struct{}
+}
+
+func (h *replayLockHolder) acquire() error {
+
+	if err := h.repository.Acquire(h.owner, time.Now().Add(replayLockTTL)); err != nil {
+		return err
+	}
+
+	// refresh the lock till it got released
+	go func() {
+
+		ticker := time.NewTicker(replayLockTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := h.repository.Refresh(h.owner, time.Now().Add(replayLockTTL)); err != nil {
+					h.logger.Error(err)
+				}
+			case <-h.stop:
+				return
+			}
+		}
+
+	}()
+
+	return nil
+
+}
+
+// release the lock and hand over to the live processors
+func (h *replayLockHolder) release(handover *replayHandover) {
+
+	close(h.stop)
+
+	if err := h.repository.Release(h.owner, handover); err != nil {
+		h.logger.Error(err)
+	}
+
+}
+
+func newReplayLockHolder(repository replayLockRepository, logger ILogger) *replayLockHolder {
+	return &replayLockHolder{
+		repository: repository,
+		logger:     logger,
+		owner:      primitive.NewObjectID().Hex(),
+		stop:       make(chan struct{}),
+	}
+}
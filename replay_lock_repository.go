@@ -0,0 +1,193 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+	"time"
+)
+
+// id of the replay lock document
+const replayLockID = "replay"
+
+var errReplayLocked = errors.New("another replay is running")
+
+// While the replay lock is held the live processors buffer the events instead of processing them. Once the lock
+// got released the processors continue after the events that got replayed.
+type replayLock struct {
+	ID        string          `bson:"_id"`
+	Owner     string          `bson:"owner"`
+	Locked    bool            `bson:"locked"`
+	ExpiresAt int64           `bson:"expires_at"`
+	Handover  *replayHandover `bson:"handover"`
+}
+
+// describes which events got applied to which projectors by a replay
+type replayHandover struct {
+	// the last replayed event
+	Event *primitive.ObjectID `bson:"event"`
+	// the replayed projectors (all projectors if empty)
+	Projectors []string `bson:"projectors"`
+	// the names of the replayed events (all events if empty)
+	Events []string `bson:"events"`
+}
+
+// check if the event got already applied to the projector by the replay
+func (h *replayHandover) replayed(projectorName string, e event.Event) bool {
+
+	if h == nil || h.Event == nil || e.ID == nil {
+		return false
+	}
+
+	if bytes.Compare(e.ID[:], h.Event[:]) > 0 {
+		return false
+	}
+
+	return (len(h.Projectors) == 0 || contains(h.Projectors, projectorName)) && (len(h.Events) == 0 || contains(h.Events, e.Name))
+
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// a lock is only held till it expires - this way a crashed replay doesn't block the processors forever
+func (l *replayLock) held(now time.Time) bool {
+	return l != nil && l.Locked && l.ExpiresAt > now.Unix()
+}
+
+type replayLockRepository interface {
+	// fetch the replay lock - nil is returned if there never was a replay
+	Fetch() (*replayLock, error)
+	// acquire the lock - errReplayLocked is returned if the lock is held by someone else
+	Acquire(owner string, expiresAt time.Time) error
+	// extend the lock
+	Refresh(owner string, expiresAt time.Time) error
+	// release the lock and hand over to the live processors
+	Release(owner string, handover *replayHandover) error
+}
+
+type mongoReplayLockRepository struct {
+	lockCollection *mongo.Collection
+}
+
+func (r *mongoReplayLockRepository) Fetch() (*replayLock, error) {
+
+	// fetch lock
+	result := r.lockCollection.FindOne(context.Background(), bson.M{
+		"_id": replayLockID,
+	})
+
+	lock := &replayLock{}
+
+	// decode fetched lock
+	err := result.Decode(lock)
+	switch err {
+	case nil:
+		return lock, nil
+	case mongo.ErrNoDocuments:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+}
+
+func (r *mongoReplayLockRepository) Acquire(owner string, expiresAt time.Time) error {
+
+	// create lock if it doesn't exist
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	// only take over the lock if it's not held. If it's held the upsert fails with a duplicate key error.
+	_, err := r.lockCollection.UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id": replayLockID,
+			"$or": bson.A{
+				bson.M{"locked": false},
+				bson.M{"expires_at": bson.M{"$lte": time.Now().Unix()}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{
+				"owner":      owner,
+				"locked":     true,
+				"expires_at": expiresAt.Unix(),
+				"handover":   nil,
+			},
+		},
+		updateOptions,
+	)
+
+	if event.IsDuplicateKeyError(err) {
+		return errReplayLocked
+	}
+
+	return err
+
+}
+
+func (r *mongoReplayLockRepository) Refresh(owner string, expiresAt time.Time) error {
+
+	result, err := r.lockCollection.UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id":    replayLockID,
+			"owner":  owner,
+			"locked": true,
+		},
+		bson.M{
+			"$set": bson.M{
+				"expires_at": expiresAt.Unix(),
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// the lock expired and got taken over by someone else
+	if result.MatchedCount == 0 {
+		return errors.New("lost replay lock")
+	}
+
+	return nil
+
+}
+
+func (r *mongoReplayLockRepository) Release(owner string, handover *replayHandover) error {
+
+	_, err := r.lockCollection.UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id":   replayLockID,
+			"owner": owner,
+		},
+		bson.M{
+			"$set": bson.M{
+				"locked":   false,
+				"handover": handover,
+			},
+		},
+	)
+
+	return err
+
+}
+
+func newReplayLockRepository(lockCollection *mongo.Collection) *mongoReplayLockRepository {
+	return &mongoReplayLockRepository{
+		lockCollection: lockCollection,
+	}
+}
@@ -0,0 +1,112 @@
+package es
+
+import (
+	"context"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestReplayLockRepository(t *testing.T) {
+
+	var createDB = func() (*mongo.Database, error) {
+
+		// create client
+		client, err := mongo.Connect(context.TODO(), "mongodb://localhost:8034")
+		if err != nil {
+			return nil, err
+		}
+
+		// database
+		db := client.Database("godb")
+		err = db.Drop(context.Background())
+
+		return db, err
+	}
+
+	Convey("replay lock repository", t, func() {
+
+		Convey("acquire and release lock", func() {
+
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			lockRepository := newReplayLockRepository(db.Collection("locks"))
+
+			// there is no lock before the first replay
+			lock, err := lockRepository.Fetch()
+			So(err, ShouldBeNil)
+			So(lock, ShouldBeNil)
+
+			// acquire lock
+			So(lockRepository.Acquire("first", time.Now().Add(time.Minute)), ShouldBeNil)
+			lock, err = lockRepository.Fetch()
+			So(err, ShouldBeNil)
+			So(lock.held(time.Now()), ShouldBeTrue)
+
+			// the lock can't be acquired twice
+			So(lockRepository.Acquire("second", time.Now().Add(time.Minute)), ShouldEqual, errReplayLocked)
+
+			// release lock
+			eventID := primitive.NewObjectID()
+			So(lockRepository.Release("first", &replayHandover{Event: &eventID}), ShouldBeNil)
+			lock, err = lockRepository.Fetch()
+			So(err, ShouldBeNil)
+			So(lock.held(time.Now()), ShouldBeFalse)
+			So(*lock.Handover.Event, ShouldEqual, eventID)
+
+			// acquire released lock
+			So(lockRepository.Acquire("second", time.Now().Add(time.Minute)), ShouldBeNil)
+
+		})
+
+		Convey("expired lock can be taken over", func() {
+
+			db, err := createDB()
+			So(err, ShouldBeNil)
+
+			lockRepository := newReplayLockRepository(db.Collection("locks"))
+
+			So(lockRepository.Acquire("crashed", time.Now().Add(-time.Second)), ShouldBeNil)
+			So(lockRepository.Acquire("second", time.Now().Add(time.Minute)), ShouldBeNil)
+
+			// the crashed replay lost its lock
+			So(lockRepository.Refresh("crashed", time.Now().Add(time.Minute)), ShouldBeError, "lost replay lock")
+
+		})
+
+	})
+
+	Convey("replay hand over", t, func() {
+
+		firstEventID := primitive.NewObjectID()
+		secondEventID := primitive.NewObjectID()
+
+		Convey("events after the hand over event weren't replayed", func() {
+			handover := &replayHandover{Event: &firstEventID}
+			So(handover.replayed("user.projector", event.Event{ID: &firstEventID}), ShouldBeTrue)
+			So(handover.replayed("user.projector", event.Event{ID: &secondEventID}), ShouldBeFalse)
+		})
+
+		Convey("only the replayed projectors and events are skipped", func() {
+			handover := &replayHandover{
+				Event:      &secondEventID,
+				Projectors: []string{"user.projector"},
+				Events:     []string{"user.registered"},
+			}
+			So(handover.replayed("user.projector", event.Event{ID: &firstEventID, Name: "user.registered"}), ShouldBeTrue)
+			So(handover.replayed("account.projector", event.Event{ID: &firstEventID, Name: "user.registered"}), ShouldBeFalse)
+			So(handover.replayed("user.projector", event.Event{ID: &firstEventID, Name: "user.deleted"}), ShouldBeFalse)
+		})
+
+		Convey("nothing got replayed without a hand over", func() {
+			var handover *replayHandover
+			So(handover.replayed("user.projector", event.Event{ID: &firstEventID}), ShouldBeFalse)
+		})
+
+	})
+
+}
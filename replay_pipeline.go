@@ -0,0 +1,311 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/projector"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"sync"
+)
+
+// size of the event queue of a replay worker
+const replayWorkerQueueSize = 100
+
+type replayEvent struct {
+	seq            int64
+	persistedEvent event.Event
+	esEvent        event.IESEvent
+}
+
+// replays the events of one projector
+type replayWorker struct {
+	projector projector.IProjector
+	events    chan replayEvent
+	// move the checkpoint of the projector
+	updateCheckpoint bool
+	// maximum amount of events handed over to a batch projector at once
+	batchSize int
+	// how the projector deals with errors
+	policy projector.ErrorPolicy
+	// records the events the projector finally failed to handle (nil if they are only logged)
+	deadLetters deadLetterRepository
+	// set once the projector failed to handle an event without parking it. The projector doesn't handle any further
+	// events then and the replay checkpoint doesn't move past the failed event.
+	err error
+	// the last event the projector handled before the replay got interrupted - it's skipped when resuming
+	handledUntil *primitive.ObjectID
+	// stops streaming the events once the projector failed (optional)
+	abort func()
+	// context the events are handled with - it carries the target of the replay
+	ctx context.Context
+}
+
+// check if the projector handled the event before the replay got interrupted
+func (w *replayWorker) handled(persistedEvent event.Event) bool {
+	return w.handledUntil != nil && persistedEvent.ID != nil && bytes.Compare(persistedEvent.ID[:], w.handledUntil[:]) <= 0
+}
+
+func (w *replayWorker) run(projectorRepository projector.IProjectorRepository, logger ILogger, semaphore chan struct{}, tracker *replayTracker) {
+
+	// batch projectors get all queued events at once
+	if batchProjector, k := w.projector.(projector.IBatchProjector); k {
+		w.runBatches(batchProjector, projectorRepository, logger, semaphore, tracker)
+		return
+	}
+
+	for e := range w.events {
+
+		// the events after a failed event stay unreplayed
+		if w.err != nil {
+			continue
+		}
+
+		// the projector handled the event before the replay got interrupted
+		if w.handled(e.persistedEvent) {
+			tracker.done(e.seq)
+			continue
+		}
+
+		// wait till we are allowed to work
+		semaphore <- struct{}{}
+
+		// handle event and update the last handled event on the projector
+		err := w.policy.Retry(w.ctx, func() error {
+			return handleEvent(w.ctx, projectorRepository, w.projector, e.persistedEvent, e.esEvent, w.updateCheckpoint)
+		})
+		if err != nil && !w.failed(projectorRepository, logger, e.persistedEvent, err) {
+			<-semaphore
+			continue
+		}
+
+		<-semaphore
+
+		tracker.done(e.seq)
+
+	}
+
+}
+
+// Deal with an event the projector finally failed to handle according to its error policy. The event is recorded as
+// dead letter. Returns true if the event got parked - the replay continues with the next event then.
+func (w *replayWorker) failed(projectorRepository projector.IProjectorRepository, logger ILogger, persistedEvent event.Event, err error) bool {
+
+	if w.deadLetters != nil && persistedEvent.ID != nil {
+		if saveErr := w.deadLetters.Save(newDeadLetter(persistedEvent, w.projector.Name(), "", err, w.policy.Retries+1)); saveErr != nil {
+			logger.Error(saveErr)
+		}
+	}
+
+	if w.policy.OnFailure == projector.OnFailurePark {
+		if w.updateCheckpoint {
+			if checkpointErr := projectorRepository.UpdateLastHandledEvent(w.projector, persistedEvent); checkpointErr != nil {
+				err = fmt.Errorf("%s (failed to park event: %s)", err, checkpointErr)
+				logger.Error(err)
+				w.err = err
+				if w.abort != nil {
+					w.abort()
+				}
+				return false
+			}
+		}
+		logger.Error(fmt.Errorf("projector '%s' parked event with name '%s': %s", w.projector.Name(), persistedEvent.Name, err))
+		return true
+	}
+
+	w.err = fmt.Errorf("projector '%s' failed to replay event with name '%s': %s", w.projector.Name(), persistedEvent.Name, err)
+	logger.Error(w.err)
+
+	// the replay checkpoint can't move past the failed event - there is no point in streaming the remaining events
+	if w.abort != nil {
+		w.abort()
+	}
+
+	return false
+
+}
+
+func (w *replayWorker) runBatches(batchProjector projector.IBatchProjector, projectorRepository projector.IProjectorRepository, logger ILogger, semaphore chan struct{}, tracker *replayTracker) {
+
+	for e := range w.events {
+
+		// the events after a failed event stay unreplayed
+		if w.err != nil {
+			continue
+		}
+
+		// collect the events that are already queued
+		batch := []replayEvent{e}
+	collect:
+		for len(batch) < w.batchSize {
+			select {
+			case next, open := <-w.events:
+				if !open {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+
+		// the projector handled some of the events before the replay got interrupted
+		unhandled := []replayEvent{}
+		for _, e := range batch {
+			if w.handled(e.persistedEvent) {
+				tracker.done(e.seq)
+				continue
+			}
+			unhandled = append(unhandled, e)
+		}
+		batch = unhandled
+		if len(batch) == 0 {
+			continue
+		}
+
+		esEvents := make([]event.IESEvent, len(batch))
+		for i, e := range batch {
+			esEvents[i] = e.esEvent
+		}
+
+		// wait till we are allowed to work
+		semaphore <- struct{}{}
+
+		// handle the events and update the last handled event on the projector once for the whole batch
+		lastEvent := batch[len(batch)-1].persistedEvent
+		err := w.policy.Retry(w.ctx, func() error {
+			return recovered(func() error {
+				return batchProjector.HandleBatch(esEvents)
+			})
+		})
+		if err == nil && w.updateCheckpoint {
+			err = projectorRepository.UpdateLastHandledEvent(w.projector, lastEvent)
+		}
+
+		// a parked batch moves the checkpoint past all of its events
+		if err != nil && !w.failed(projectorRepository, logger, lastEvent, err) {
+			<-semaphore
+			continue
+		}
+
+		<-semaphore
+
+		for _, e := range batch {
+			tracker.done(e.seq)
+		}
+
+	}
+
+}
+
+func newReplayWorker(p projector.IProjector, policy projector.ErrorPolicy, deadLetters deadLetterRepository, updateCheckpoint bool, batchSize int) *replayWorker {
+
+	// batch projectors need a queue that is able to hold a whole batch
+	queueSize := replayWorkerQueueSize
+	if _, k := p.(projector.IBatchProjector); k && batchSize > queueSize {
+		queueSize = batchSize
+	}
+
+	return &replayWorker{
+		projector:        p,
+		events:           make(chan replayEvent, queueSize),
+		updateCheckpoint: updateCheckpoint,
+		batchSize:        batchSize,
+		policy:           policy,
+		deadLetters:      deadLetters,
+		ctx:              context.Background(),
+	}
+
+}
+
+type trackedEvent struct {
+	id primitive.ObjectID
+	// amount of projectors that still have to handle the event
+	remaining int
+}
+
+// keeps track of the events that got replayed by all their projectors.
+// Since the projectors are working in parallel an event only counts as replayed if it and all events before it got handled.
+type replayTracker struct {
+	lock *sync.Mutex
+	// events that haven't been handled by all their projectors yet (ordered by their sequence number)
+	inFlight []*trackedEvent
+	// sequence number of the first in flight event
+	offset            int64
+	processed         int64
+	lastReplayedEvent *primitive.ObjectID
+}
+
+// add an event that must be handled by the given amount of projectors. Returns the sequence number of the event.
+func (t *replayTracker) add(eventID primitive.ObjectID, projectors int) int64 {
+
+	// lock
+	t.lock.Lock()
+	defer func() {
+		t.lock.Unlock()
+	}()
+
+	seq := t.offset + int64(len(t.inFlight))
+
+	t.inFlight = append(t.inFlight, &trackedEvent{
+		id:        eventID,
+		remaining: projectors,
+	})
+
+	t.advance()
+
+	return seq
+
+}
+
+// mark the event with the given sequence number as handled by one of its projectors
+func (t *replayTracker) done(seq int64) {
+
+	// lock
+	t.lock.Lock()
+	defer func() {
+		t.lock.Unlock()
+	}()
+
+	t.inFlight[seq-t.offset].remaining--
+
+	t.advance()
+
+}
+
+// the last event that got replayed together with the amount of replayed events
+func (t *replayTracker) checkpoint() (*primitive.ObjectID, int64) {
+
+	// lock
+	t.lock.Lock()
+	defer func() {
+		t.lock.Unlock()
+	}()
+
+	return t.lastReplayedEvent, t.processed
+
+}
+
+// move the checkpoint over all events at the beginning of the queue that were handled by all their projectors
+func (t *replayTracker) advance() {
+
+	for len(t.inFlight) > 0 && t.inFlight[0].remaining <= 0 {
+		replayedEvent := t.inFlight[0]
+		t.inFlight[0] = nil
+		t.inFlight = t.inFlight[1:]
+		t.offset++
+		t.processed++
+		t.lastReplayedEvent = &replayedEvent.id
+	}
+
+}
+
+func newReplayTracker(lastReplayedEvent *primitive.ObjectID, processed int64) *replayTracker {
+	return &replayTracker{
+		lock:              &sync.Mutex{},
+		inFlight:          []*trackedEvent{},
+		processed:         processed,
+		lastReplayedEvent: lastReplayedEvent,
+	}
+}
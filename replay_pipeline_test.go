@@ -0,0 +1,320 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"github.com/florianlenz/event-sourcing-go/event"
+	"github.com/florianlenz/event-sourcing-go/projector"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+)
+
+func TestReplayTracker(t *testing.T) {
+
+	Convey("replay tracker", t, func() {
+
+		Convey("event without projectors counts as replayed", func() {
+
+			tracker := newReplayTracker(nil, 0)
+
+			eventID := primitive.NewObjectID()
+			tracker.add(eventID, 0)
+
+			lastReplayedEvent, processed := tracker.checkpoint()
+			So(*lastReplayedEvent, ShouldEqual, eventID)
+			So(processed, ShouldEqual, 1)
+
+		})
+
+		Convey("event only counts as replayed once all projectors handled it", func() {
+
+			tracker := newReplayTracker(nil, 0)
+
+			eventID := primitive.NewObjectID()
+			seq := tracker.add(eventID, 2)
+
+			tracker.done(seq)
+			lastReplayedEvent, processed := tracker.checkpoint()
+			So(lastReplayedEvent, ShouldBeNil)
+			So(processed, ShouldEqual, 0)
+
+			tracker.done(seq)
+			lastReplayedEvent, processed = tracker.checkpoint()
+			So(*lastReplayedEvent, ShouldEqual, eventID)
+			So(processed, ShouldEqual, 1)
+
+		})
+
+		Convey("checkpoint must not pass events that are still in flight", func() {
+
+			previousEventID := primitive.NewObjectID()
+			tracker := newReplayTracker(&previousEventID, 10)
+
+			firstEventID := primitive.NewObjectID()
+			firstSeq := tracker.add(firstEventID, 1)
+
+			secondEventID := primitive.NewObjectID()
+			secondSeq := tracker.add(secondEventID, 1)
+
+			// the second event got handled before the first one
+			tracker.done(secondSeq)
+			lastReplayedEvent, processed := tracker.checkpoint()
+			So(*lastReplayedEvent, ShouldEqual, previousEventID)
+			So(processed, ShouldEqual, 10)
+
+			tracker.done(firstSeq)
+			lastReplayedEvent, processed = tracker.checkpoint()
+			So(*lastReplayedEvent, ShouldEqual, secondEventID)
+			So(processed, ShouldEqual, 12)
+
+		})
+
+	})
+
+}
+
+func TestReplayWorker(t *testing.T) {
+
+	Convey("replay worker", t, func() {
+
+		Convey("batch projector must get the queued events at once", func() {
+
+			// projector repository
+			checkpoints := []primitive.ObjectID{}
+			projectorRepository := &testProjectorRepository{
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					checkpoints = append(checkpoints, *event.ID)
+					return nil
+				},
+			}
+
+			// batch projector
+			batches := [][]event.IESEvent{}
+			batchProjector := &testBatchProjector{
+				testProjector: &testProjector{
+					name: "user.projector",
+				},
+				handleBatch: func(events []event.IESEvent) error {
+					batches = append(batches, events)
+					return nil
+				},
+			}
+
+			worker := newReplayWorker(batchProjector, projector.ErrorPolicy{}, nil, true, 2)
+			tracker := newReplayTracker(nil, 0)
+
+			// queue events
+			eventIDs := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+			for _, eventID := range eventIDs {
+				id := eventID
+				worker.events <- replayEvent{
+					seq:            tracker.add(id, 1),
+					persistedEvent: event.Event{ID: &id},
+					esEvent:        testEvent{},
+				}
+			}
+			close(worker.events)
+
+			worker.run(projectorRepository, &testLogger{}, make(chan struct{}, 1), tracker)
+
+			// the events are handled in batches of the configured size
+			So(batches, ShouldHaveLength, 2)
+			So(batches[0], ShouldHaveLength, 2)
+			So(batches[1], ShouldHaveLength, 1)
+
+			// the checkpoint is updated once per batch
+			So(checkpoints, ShouldResemble, []primitive.ObjectID{eventIDs[1], eventIDs[2]})
+
+			lastReplayedEvent, processed := tracker.checkpoint()
+			So(*lastReplayedEvent, ShouldEqual, eventIDs[2])
+			So(processed, ShouldEqual, 3)
+
+		})
+
+		Convey("context projectors must get the target of the replay", func() {
+
+			config := &replayConfig{}
+			ReplayInMemory()(config)
+
+			sandboxed := false
+			contextProjector := &testContextProjector{
+				testProjector: &testProjector{
+					name: "user.projector",
+				},
+				handleContext: func(ctx context.Context, e event.IESEvent) error {
+					_, sandboxed = ReplayTarget(ctx)
+					return nil
+				},
+			}
+
+			worker := newReplayWorker(contextProjector, projector.ErrorPolicy{}, nil, true, 1)
+			worker.ctx = config.handlerContext()
+			tracker := newReplayTracker(nil, 0)
+
+			id := primitive.NewObjectID()
+			worker.events <- replayEvent{
+				seq:            tracker.add(id, 1),
+				persistedEvent: event.Event{ID: &id},
+				esEvent:        testEvent{},
+			}
+			close(worker.events)
+
+			projectorRepository := &testProjectorRepository{
+				updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+					return nil
+				},
+			}
+			worker.run(projectorRepository, &testLogger{}, make(chan struct{}, 1), tracker)
+
+			So(sandboxed, ShouldBeTrue)
+
+		})
+
+		Convey("a resumed replay must skip the events the projector handled before it got interrupted", func() {
+
+			eventIDs := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+
+			// the replay got interrupted after the projector handled the second event
+			for _, batchSize := range []int{1, 3} {
+
+				// projector repository
+				checkpoints := []primitive.ObjectID{}
+				projectorRepository := &testProjectorRepository{
+					updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+						checkpoints = append(checkpoints, *event.ID)
+						return nil
+					},
+				}
+
+				handled := 0
+				var p projector.IProjector = &testProjector{
+					name: "user.projector",
+					handleEvent: func(e event.IESEvent) error {
+						handled++
+						return nil
+					},
+				}
+				if batchSize > 1 {
+					p = &testBatchProjector{
+						testProjector: &testProjector{
+							name: "user.projector",
+						},
+						handleBatch: func(events []event.IESEvent) error {
+							handled += len(events)
+							return nil
+						},
+					}
+				}
+
+				worker := newReplayWorker(p, projector.ErrorPolicy{}, nil, true, batchSize)
+				worker.handledUntil = &eventIDs[1]
+				tracker := newReplayTracker(nil, 0)
+
+				// the replay checkpoint got persisted before the first event
+				for _, eventID := range eventIDs {
+					id := eventID
+					worker.events <- replayEvent{
+						seq:            tracker.add(id, 1),
+						persistedEvent: event.Event{ID: &id},
+						esEvent:        testEvent{},
+					}
+				}
+				close(worker.events)
+
+				worker.run(projectorRepository, &testLogger{}, make(chan struct{}, 1), tracker)
+
+				So(handled, ShouldEqual, 2)
+				So(checkpoints[len(checkpoints)-1], ShouldEqual, eventIDs[3])
+
+				lastReplayedEvent, processed := tracker.checkpoint()
+				So(*lastReplayedEvent, ShouldEqual, eventIDs[3])
+				So(processed, ShouldEqual, 4)
+
+			}
+
+		})
+
+		Convey("failed events must be dead lettered and stop the checkpoint unless they got parked", func() {
+
+			for _, policy := range []projector.ErrorPolicy{{Retries: 1}, {Retries: 1, OnFailure: projector.OnFailurePark}} {
+
+				// projector repository
+				checkpoints := []primitive.ObjectID{}
+				projectorRepository := &testProjectorRepository{
+					updateLastHandledEvent: func(projector projector.IProjector, event event.Event) error {
+						checkpoints = append(checkpoints, *event.ID)
+						return nil
+					},
+				}
+
+				// the projector fails to handle the second event (and its retry)
+				eventIDs := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+				handled := 0
+				failingProjector := &testProjector{
+					name: "user.projector",
+					handleEvent: func(e event.IESEvent) error {
+						handled++
+						if handled == 2 || handled == 3 {
+							return errors.New("read model is gone")
+						}
+						return nil
+					},
+				}
+
+				deadLetters := &testDeadLetterRepository{lock: &sync.Mutex{}}
+				worker := newReplayWorker(failingProjector, policy, deadLetters, true, 1)
+				aborted := false
+				worker.abort = func() {
+					aborted = true
+				}
+				tracker := newReplayTracker(nil, 0)
+
+				// queue events
+				for _, eventID := range eventIDs {
+					id := eventID
+					worker.events <- replayEvent{
+						seq:            tracker.add(id, 1),
+						persistedEvent: event.Event{ID: &id, Name: "user.registered"},
+						esEvent:        testEvent{},
+					}
+				}
+				close(worker.events)
+
+				logger := &testLogger{errorChan: make(chan error, 1)}
+				worker.run(projectorRepository, logger, make(chan struct{}, 1), tracker)
+
+				// the failed event got retried and recorded as dead letter
+				So(deadLetters.letters, ShouldHaveLength, 1)
+				So(deadLetters.letters[0].EventID, ShouldEqual, eventIDs[1])
+				So(deadLetters.letters[0].Projector, ShouldEqual, "user.projector")
+				So(deadLetters.letters[0].Attempts, ShouldEqual, 2)
+
+				lastReplayedEvent, processed := tracker.checkpoint()
+				if policy.OnFailure == projector.OnFailurePark {
+					So(worker.err, ShouldBeNil)
+					So(aborted, ShouldBeFalse)
+					So(<-logger.errorChan, ShouldBeError, "projector 'user.projector' parked event with name 'user.registered': read model is gone")
+					So(handled, ShouldEqual, 4)
+					So(checkpoints, ShouldResemble, eventIDs)
+					So(*lastReplayedEvent, ShouldEqual, eventIDs[2])
+					So(processed, ShouldEqual, 3)
+					continue
+				}
+
+				So(worker.err, ShouldBeError, "projector 'user.projector' failed to replay event with name 'user.registered': read model is gone")
+				So(<-logger.errorChan, ShouldEqual, worker.err)
+				So(aborted, ShouldBeTrue)
+				So(handled, ShouldEqual, 3)
+				So(checkpoints, ShouldResemble, eventIDs[:1])
+				So(*lastReplayedEvent, ShouldEqual, eventIDs[0])
+				So(processed, ShouldEqual, 1)
+
+			}
+
+		})
+
+	})
+
+}
@@ -5,9 +5,11 @@ import (
 	"github.com/florianlenz/event-sourcing-go/event"
 	"github.com/florianlenz/event-sourcing-go/projector"
 	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 type replayTestEventPayload struct {
@@ -104,4 +106,401 @@ func TestReplay(t *testing.T) {
 
 	})
 
+	Convey("must resume an interrupted replay", t, func() {
+
+		// logger
+		logger := &testLogger{
+			errorChan: make(chan error, 10),
+		}
+
+		// db
+		db, err := createDB()
+		So(err, ShouldBeNil)
+		eventCollection := db.Collection("events")
+
+		// first event
+		firstEvent, err := eventCollection.InsertOne(context.Background(), bson.M{
+			"name": "user.registered",
+			"payload": bson.M{
+				"event": "one",
+			},
+		})
+		So(err, ShouldBeNil)
+		firstEventID := firstEvent.InsertedID.(primitive.ObjectID)
+
+		// second event
+		_, err = eventCollection.InsertOne(context.Background(), bson.M{
+			"name": "user.registered",
+			"payload": bson.M{
+				"event": "two",
+			},
+		})
+		So(err, ShouldBeNil)
+
+		// checkpoint of a replay that got interrupted after the first event
+		checkpointRepository := newReplayCheckpointRepository(db.Collection("replay_checkpoints"))
+		So(checkpointRepository.Save(replayCheckpoint{
+			Name:              "replay",
+			LastReplayedEvent: &firstEventID,
+			Processed:         1,
+		}), ShouldBeNil)
+
+		// projected events channel
+		projectedEvents := make(chan event.IESEvent, 2)
+
+		// projector registry
+		projectorRegistry := projector.NewProjectorRegistry()
+		So(projectorRegistry.Register(&testProjector{
+			name: "user_projector",
+			interestedInEvents: []event.IESEvent{
+				replayTestEvent{},
+			},
+			handleEvent: func(event event.IESEvent) error {
+				projectedEvents <- event
+				return nil
+			},
+		}), ShouldBeNil)
+
+		//  register test event
+		eventRegistry := event.NewEventRegistry()
+		So(eventRegistry.RegisterEvent("user.registered", replayTestEvent{}), ShouldBeNil)
+
+		// replay and collect the reported progress
+		reportedProgress := make(chan ReplayProgress, 10)
+		done := Replay(logger, db, projectorRegistry, eventRegistry, ReplayWithProgress(0, func(progress ReplayProgress) {
+			reportedProgress <- progress
+		}))
+		So(<-done, ShouldBeNil)
+
+		// only the second event should have been replayed
+		So(projectedEvents, ShouldHaveLength, 1)
+		So(<-projectedEvents, ShouldResemble, replayTestEvent{
+			Payload: replayTestEventPayload{
+				Event: "two",
+			},
+		})
+
+		// the progress should include the events replayed before the interruption
+		progress := <-reportedProgress
+		So(progress.Processed, ShouldEqual, 2)
+		So(progress.Total, ShouldEqual, 2)
+
+		// the checkpoint should be removed once the replay is done
+		checkpoint, err := checkpointRepository.Fetch("replay")
+		So(err, ShouldBeNil)
+		So(checkpoint, ShouldBeNil)
+
+	})
+
+	Convey("must only replay the selected projectors and events", t, func() {
+
+		// logger
+		logger := &testLogger{
+			errorChan: make(chan error, 10),
+		}
+
+		// db
+		db, err := createDB()
+		So(err, ShouldBeNil)
+		eventCollection := db.Collection("events")
+
+		// first event
+		_, err = eventCollection.InsertOne(context.Background(), bson.M{
+			"name":        "user.registered",
+			"occurred_at": 100,
+			"payload": bson.M{
+				"event": "one",
+			},
+		})
+		So(err, ShouldBeNil)
+
+		// second event
+		_, err = eventCollection.InsertOne(context.Background(), bson.M{
+			"name":        "user.registered",
+			"occurred_at": 200,
+			"payload": bson.M{
+				"event": "two",
+			},
+		})
+		So(err, ShouldBeNil)
+
+		// checkpoint of the projector that is not replayed
+		_, err = db.Collection("projectors").InsertOne(context.Background(), bson.M{
+			"name": "account_projector",
+		})
+		So(err, ShouldBeNil)
+
+		// projected events channel
+		projectedEvents := make(chan event.IESEvent, 2)
+
+		// projector registry
+		projectorRegistry := projector.NewProjectorRegistry()
+		So(projectorRegistry.Register(&testProjector{
+			name: "user_projector",
+			interestedInEvents: []event.IESEvent{
+				replayTestEvent{},
+			},
+			handleEvent: func(event event.IESEvent) error {
+				projectedEvents <- event
+				return nil
+			},
+		}), ShouldBeNil)
+		So(projectorRegistry.Register(&testProjector{
+			name: "account_projector",
+			interestedInEvents: []event.IESEvent{
+				replayTestEvent{},
+			},
+			handleEvent: func(event event.IESEvent) error {
+				panic("account projector is not supposed to be replayed")
+			},
+		}), ShouldBeNil)
+
+		//  register test event
+		eventRegistry := event.NewEventRegistry()
+		So(eventRegistry.RegisterEvent("user.registered", replayTestEvent{}), ShouldBeNil)
+
+		// replay the user projector up to the first event
+		done := Replay(logger, db, projectorRegistry, eventRegistry, ReplayProjectors("user_projector"), ReplayEvents("user.registered"), ReplayOccurredBetween(time.Time{}, time.Unix(150, 0)))
+		So(<-done, ShouldBeNil)
+
+		So(projectedEvents, ShouldHaveLength, 1)
+		So(<-projectedEvents, ShouldResemble, replayTestEvent{
+			ESEvent: event.NewESEvent(100, 0),
+			Payload: replayTestEventPayload{
+				Event: "one",
+			},
+		})
+
+		// the checkpoint of the account projector must be untouched
+		count, err := db.Collection("projectors").Count(context.Background(), bson.M{"name": "account_projector"})
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+	})
+
+	Convey("must write the checkpoints into the sandbox database", t, func() {
+
+		// logger
+		logger := &testLogger{
+			errorChan: make(chan error, 10),
+		}
+
+		// db
+		db, err := createDB()
+		So(err, ShouldBeNil)
+
+		// sandbox db
+		sandbox := db.Client().Database("godb_sandbox")
+		So(sandbox.Drop(context.Background()), ShouldBeNil)
+
+		// event
+		_, err = db.Collection("events").InsertOne(context.Background(), bson.M{
+			"name": "user.registered",
+			"payload": bson.M{
+				"event": "one",
+			},
+		})
+		So(err, ShouldBeNil)
+
+		// checkpoint of the live projector
+		_, err = db.Collection("projectors").InsertOne(context.Background(), bson.M{
+			"name": "user_projector",
+		})
+		So(err, ShouldBeNil)
+
+		// projector registry
+		projectorRegistry := projector.NewProjectorRegistry()
+		So(projectorRegistry.Register(&testProjector{
+			name: "user_projector",
+			interestedInEvents: []event.IESEvent{
+				replayTestEvent{},
+			},
+			handleEvent: func(event event.IESEvent) error {
+				return nil
+			},
+		}), ShouldBeNil)
+
+		//  register test event
+		eventRegistry := event.NewEventRegistry()
+		So(eventRegistry.RegisterEvent("user.registered", replayTestEvent{}), ShouldBeNil)
+
+		done := Replay(logger, db, projectorRegistry, eventRegistry, ReplayInto(sandbox))
+		So(<-done, ShouldBeNil)
+
+		// the live checkpoint must be untouched
+		count, err := db.Collection("projectors").Count(context.Background(), bson.M{"last_processed_event": bson.M{"$exists": true}})
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+
+		// the sandbox checkpoint must point to the replayed event
+		count, err = sandbox.Collection("projectors").Count(context.Background(), bson.M{"name": "user_projector"})
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+	})
+
+	Convey("must keep the checkpoints of the reactors", t, func() {
+
+		// logger
+		logger := &testLogger{
+			errorChan: make(chan error, 10),
+		}
+
+		// db
+		db, err := createDB()
+		So(err, ShouldBeNil)
+
+		// event
+		insertedEvent, err := db.Collection("events").InsertOne(context.Background(), bson.M{
+			"name": "user.registered",
+			"payload": bson.M{
+				"event": "one",
+			},
+		})
+		So(err, ShouldBeNil)
+		eventID := insertedEvent.InsertedID.(primitive.ObjectID)
+
+		//  register test event
+		eventRegistry := event.NewEventRegistry()
+		So(eventRegistry.RegisterEvent("user.registered", replayTestEvent{}), ShouldBeNil)
+
+		// the reactor already reacted on the event
+		reactorCheckpointRepository := newReactorCheckpointRepository(db, eventRegistry)
+		So(reactorCheckpointRepository.UpdateLastHandledEvent(&reactorCheckpoint{reactorName: "mailer"}, event.Event{ID: &eventID}), ShouldBeNil)
+
+		// projector registry
+		projectorRegistry := projector.NewProjectorRegistry()
+		So(projectorRegistry.Register(&testProjector{
+			name: "user_projector",
+			interestedInEvents: []event.IESEvent{
+				replayTestEvent{},
+			},
+			handleEvent: func(event event.IESEvent) error {
+				return nil
+			},
+		}), ShouldBeNil)
+
+		// replaying all projectors resets their checkpoints
+		done := Replay(logger, db, projectorRegistry, eventRegistry)
+		So(<-done, ShouldBeNil)
+
+		// the reactor must not react on the event again
+		checkpoint, err := reactorCheckpointRepository.LastHandledEvent(&reactorCheckpoint{reactorName: "mailer"})
+		So(err, ShouldBeNil)
+		So(checkpoint, ShouldNotBeNil)
+		So(*checkpoint, ShouldEqual, eventID)
+
+	})
+
+	Convey("replay config", t, func() {
+
+		Convey("replays that don't start with the first event are partial", func() {
+
+			config := &replayConfig{}
+			So(config.partial(), ShouldBeFalse)
+
+			ReplayOccurredBetween(time.Time{}, time.Unix(100, 0))(config)
+			So(config.partial(), ShouldBeFalse)
+
+			ReplayProjectors("user_projector")(config)
+			So(config.partial(), ShouldBeFalse)
+
+			ReplayOccurredBetween(time.Unix(50, 0), time.Unix(100, 0))(config)
+			So(config.partial(), ShouldBeTrue)
+
+			config = &replayConfig{}
+			ReplayEvents("user.registered")(config)
+			So(config.partial(), ShouldBeTrue)
+
+		})
+
+		Convey("replays that stop before the last event can't rebuild the live projectors", func() {
+
+			eventID := primitive.NewObjectID()
+
+			config := &replayConfig{}
+			ReplayUntil(eventID)(config)
+			So(config.rewindsLiveProjectors(), ShouldBeTrue)
+
+			// there is no hand over to the live processors
+			err := replay(&testLogger{}, nil, projector.NewProjectorRegistry(), event.NewEventRegistry(), config)
+			So(err, ShouldBeError, "a replay that stops before the last event can't rebuild the live projectors - replay into another database or in memory")
+
+			config = &replayConfig{}
+			ReplayOccurredBetween(time.Time{}, time.Unix(100, 0))(config)
+			ReplayProjectors("user_projector")(config)
+			So(config.rewindsLiveProjectors(), ShouldBeTrue)
+
+			// partial replays repair the live projectors
+			ReplayEvents("user.registered")(config)
+			So(config.rewindsLiveProjectors(), ShouldBeFalse)
+
+			// sandboxes can be rebuilt to a past state
+			config = &replayConfig{}
+			ReplayUntil(eventID)(config)
+			ReplayInMemory()(config)
+			So(config.rewindsLiveProjectors(), ShouldBeFalse)
+
+		})
+
+		Convey("replays into a sandbox pass their target to the projectors", func() {
+
+			client, err := mongo.NewClient("mongodb://localhost:8034")
+			So(err, ShouldBeNil)
+			sandbox := client.Database("godb_sandbox")
+
+			// the live projectors aren't sandboxed
+			db, sandboxed := ReplayTarget((&replayConfig{}).handlerContext())
+			So(db, ShouldBeNil)
+			So(sandboxed, ShouldBeFalse)
+
+			config := &replayConfig{}
+			ReplayInto(sandbox)(config)
+			db, sandboxed = ReplayTarget(config.handlerContext())
+			So(db, ShouldEqual, sandbox)
+			So(sandboxed, ShouldBeTrue)
+
+			config = &replayConfig{}
+			ReplayInMemory()(config)
+			db, sandboxed = ReplayTarget(config.handlerContext())
+			So(db, ShouldBeNil)
+			So(sandboxed, ShouldBeTrue)
+
+		})
+
+		Convey("checkpoint name depends on the filters", func() {
+
+			config := &replayConfig{}
+			So(config.checkpointName(), ShouldEqual, "replay")
+
+			ReplayEvents("user.registered", "user.deleted")(config)
+			ReplayProjectors("user_projector")(config)
+			So(config.checkpointName(), ShouldEqual, "replay?events=user.deleted%2Cuser.registered&projectors=user_projector")
+
+		})
+
+		Convey("replaying an unregistered projector should fail", func() {
+
+			_, err := selectProjectors(projector.NewProjectorRegistry(), []string{"user_projector"})
+			So(err, ShouldBeError, "projector 'user_projector' hasn't been registered")
+
+		})
+
+	})
+
+	Convey("replay progress", t, func() {
+
+		Convey("calculate rate and eta", func() {
+			progress := newReplayProgress(150, 250, 100, time.Second*10)
+			So(progress.Rate, ShouldEqual, 10)
+			So(progress.ETA, ShouldEqual, time.Second*10)
+		})
+
+		Convey("eta must not be negative if more events got replayed than counted", func() {
+			progress := newReplayProgress(300, 250, 100, time.Second*10)
+			So(progress.ETA, ShouldEqual, 0)
+		})
+
+	})
+
 }